@@ -0,0 +1,860 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/audit"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/config"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/diff"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/format"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/reconcile"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/snapshot"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/tlsutil"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/version"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// commandCaller identifies changes made from the command line in the audit
+// log
+const commandCaller = "cli"
+
+const usage = `Usage: external-dns-usg-dns-api [flags]
+       external-dns-usg-dns-api command [arguments]
+
+Without a command, the webhook server is started.
+
+Commands:
+  audit verify [FILE]            Check the hash chain of the audit log (default: $AUDIT_LOG_FILE)
+  check [-write] [-canary NAME]  Validate the configuration, reach usg-dns-api and verify the token with a read;
+                                 -write also creates and deletes a canary record to verify write permission
+  config [flags]                 Print the effective configuration as YAML, with secrets redacted
+  export [-format F] [-dir DIR]  Write the records within the domain filter as zone, hosts, dnsmasq, json or yaml
+                                 (-dir writes one zone file per zone of the domain filter)
+  import [-format F] [-conflict C] [-dry-run] FILE
+                                 Create the records of a zone, hosts, json or yaml file ("-" for stdin);
+                                 existing names with other targets are skipped, overwritten or fail the import
+  plan [-json] FILE               Show what the webhook would do with a captured external-dns changes payload ("-" for stdin)
+  records list [-name GLOB] [-target CIDR] [-all] [-output F]
+                                 List the records within the domain filter (-all for every record)
+                                 as a table, json or yaml
+  records get [-output F] NAME|ID
+                                 Show the records of a name, or the record of an ID
+  records create [-dry-run] NAME TARGET
+  records update [-dry-run] NAME|ID TARGET
+  records delete [-dry-run] [-name GLOB] [-target CIDR] [NAME...]
+                                 Change records through the same checks as the webhook: domain filter,
+                                 DRY_RUN, MAX_DELETIONS, audit log and snapshots
+  reconcile [-once] [-interval D] [-dry-run] [-allow-empty] FILE
+                                 Make the records within the domain filter match a YAML or JSON list of endpoints,
+                                 continuously and whenever FILE changes unless -once is given
+  replicate [-once] [-interval D] [-dry-run]
+                                 Replicate the records within the domain filter from the first URL of USG_DNS_URL
+                                 to the others, as set by REPLICATION_MODE and REPLICATION_AUTHORITY
+  restore -list                  List the snapshots of $SNAPSHOT_DIR
+  restore [-dry-run] SNAPSHOT    Restore the records within the domain filter from a snapshot ("latest" for the newest)
+
+Commands other than audit read the same configuration as the server. The config
+file can be given to them with -config FILE.
+`
+
+// runCommand runs a command given on the command line and returns the exit
+// code of the process
+func runCommand(args []string) int {
+	switch args[0] {
+	case "audit":
+		return runAudit(args[1:])
+	case "export":
+		return runExport(args[1:])
+	case "import":
+		return runImport(args[1:])
+	case "plan":
+		return runPlan(args[1:])
+	case "reconcile":
+		return runReconcile(args[1:])
+	case "replicate":
+		return runReplicate(args[1:])
+	case "restore":
+		return runRestore(args[1:])
+	case "config":
+		return runConfig(args[1:])
+	case "check":
+		return runCheck(args[1:])
+	case "records":
+		return runRecords(args[1:])
+	case "help":
+		printUsage(os.Stdout)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		printUsage(os.Stderr)
+		return 2
+	}
+}
+
+// printUsage writes the commands and the flags of the server to w
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, usage)
+	fmt.Fprintln(w, "\nFlags (each can also be set with the upper case environment variable, or the")
+	fmt.Fprintln(w, "lower case key with underscores in the config file; flags take precedence over")
+	fmt.Fprintln(w, "the environment, which takes precedence over the config file):")
+	config.PrintUsage(w)
+}
+
+// configFlag adds the -config flag shared by the commands reading the
+// configuration
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", "", "YAML or TOML config file (default: $CONFIG_FILE)")
+}
+
+// loadConfig loads the configuration, from the given config file if any
+func loadConfig(configFile string) (*config.Config, error) {
+	if configFile == "" {
+		return config.LoadConfig()
+	}
+	return config.Load([]string{"--config", configFile})
+}
+
+// newClient creates the usg-dns-api client, failing over across the URLs
+// of USG_DNS_URL, sending its token as set by USG_DNS_AUTH_MODE, reading it
+// again from USG_DNS_TOKEN_FILE when it is rejected, connecting with the
+// TLS settings of usg-dns-api and holding back requests with the rate
+// limit and circuit breaker
+func newClient(cfg *config.Config) (*usgdns.Client, error) {
+	opts := []usgdns.Option{
+		usgdns.WithFailover(cfg.URLs[1:]...),
+		usgdns.WithWriteMode(cfg.WriteMode),
+		usgdns.WithTokenFile(cfg.TokenFile),
+		usgdns.WithRateLimit(cfg.RateLimit, cfg.RateBurst),
+		usgdns.WithCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+	}
+
+	switch cfg.ClientAuthMode {
+	case usgdns.AuthBearer:
+		opts = append(opts, usgdns.WithAuthenticator(usgdns.BearerToken()))
+	case usgdns.AuthBasic:
+		opts = append(opts, usgdns.WithAuthenticator(usgdns.BasicAuth(cfg.ClientAuthUsername)))
+	case usgdns.AuthHeader:
+		opts = append(opts, usgdns.WithAuthenticator(usgdns.HeaderToken(cfg.ClientAuthHeader)))
+	case usgdns.AuthHMAC:
+		opts = append(opts, usgdns.WithAuthenticator(usgdns.HMACSignature()))
+	}
+
+	if cfg.ClientTLSEnabled() {
+		// Without USG_DNS_SERVER_NAME, the certificate of each URL is
+		// verified against its host
+		tlsConfig, err := tlsutil.NewClientConfig(tlsutil.ClientOptions{
+			CAFile:             cfg.CAFile,
+			CertFile:           cfg.ClientCertFile,
+			KeyFile:            cfg.ClientKeyFile,
+			ServerName:         cfg.ServerName,
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+			Pins:               cfg.Pins,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load usg-dns-api TLS configuration: %w", err)
+		}
+		opts = append(opts, usgdns.WithTLS(tlsConfig))
+	}
+
+	return usgdns.NewClient(cfg.URLs[0], cfg.Token, opts...), nil
+}
+
+func runConfig(args []string) int {
+	cfg, err := config.Load(args)
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stdout)
+		return 0
+	}
+	if errors.Is(err, config.ErrVersion) {
+		fmt.Println(version.VersionFull())
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+
+	data, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print the configuration: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
+
+// canaryTarget is the address of canary records, from TEST-NET-1 so that it
+// never routes anywhere
+const canaryTarget = "192.0.2.1"
+
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	write := flags.Bool("write", false, "create and delete a canary record to verify write permission")
+	canary := flags.String("canary", "", "name of the canary record (default: a unique name within the first domain of the domain filter)")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of the whole check")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Println("FAIL configuration is invalid:")
+		for _, line := range strings.Split(err.Error(), "\n") {
+			fmt.Printf("     %s\n", line)
+		}
+		return 1
+	}
+	fmt.Println("OK   configuration is valid")
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	// Every URL is checked, so that a broken one is found before failing
+	// over to it
+	var records []usgdns.Record
+	failed := false
+	for i, u := range cfg.URLs {
+		single := *cfg
+		single.URLs = []string{u}
+		client, err := newClient(&single)
+		if err != nil {
+			fmt.Printf("FAIL %v\n", err)
+			return 1
+		}
+		backendRecords, err := client.GetRecords(ctx)
+		if err != nil {
+			fmt.Printf("FAIL %s\n", checkHint(&single, "read records", err))
+			failed = true
+			continue
+		}
+
+		prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun)
+		managed := 0
+		for _, record := range backendRecords {
+			if prov.Manages(record.Name) {
+				managed++
+			}
+		}
+		fmt.Printf("OK   usg-dns-api at %s accepted the token: %d records, %d within the domain filter\n", u, len(backendRecords), managed)
+		if i == 0 {
+			records = backendRecords
+		}
+	}
+	if failed {
+		return 1
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		fmt.Printf("FAIL %v\n", err)
+		return 1
+	}
+	prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun)
+
+	if !*write {
+		return 0
+	}
+
+	name := *canary
+	if name == "" {
+		if len(cfg.DomainFilter) == 0 {
+			fmt.Println("FAIL -write needs -canary NAME when DOMAIN_FILTER is not set")
+			return 2
+		}
+		domain := strings.Trim(cfg.DomainFilter[0], ".")
+		name = fmt.Sprintf("external-dns-usg-dns-api-check-%d.%s", time.Now().Unix(), domain)
+	}
+	if !prov.Manages(name) {
+		fmt.Printf("FAIL canary record %s is outside of the domain filter\n", name)
+		return 2
+	}
+	for _, record := range records {
+		if record.Name == name {
+			fmt.Printf("FAIL canary record %s already exists, choose another name with -canary\n", name)
+			return 2
+		}
+	}
+
+	record, err := client.CreateRecord(ctx, name, canaryTarget)
+	if err != nil {
+		fmt.Printf("FAIL %s\n", checkHint(cfg, "create canary record "+name, err))
+		return 1
+	}
+	if err := client.DeleteRecord(ctx, *record); err != nil {
+		fmt.Printf("FAIL %s\n", checkHint(cfg, "delete canary record "+name, err))
+		fmt.Printf("     the canary record was left behind with ID %s, delete it by hand\n", record.ID)
+		return 1
+	}
+	fmt.Printf("OK   token can write: created and deleted canary record %s\n", name)
+	return 0
+}
+
+// checkHint describes a failed call to usg-dns-api with what to look at
+func checkHint(cfg *config.Config, action string, err error) string {
+	var transportErr *usgdns.TransportError
+	var apiErr *usgdns.APIError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.Is(err, tlsutil.ErrPinMismatch):
+		return fmt.Sprintf("failed to %s: %v\n     check USG_DNS_PINS, or add the pin of the new certificate before it is rotated", action, err)
+	case errors.As(err, &authorityErr):
+		return fmt.Sprintf("failed to %s: %v\n     set USG_DNS_CA_FILE to the CA bundle which signed the certificate of usg-dns-api", action, err)
+	case errors.As(err, &hostnameErr):
+		return fmt.Sprintf("failed to %s: %v\n     set USG_DNS_SERVER_NAME to a name of the certificate of usg-dns-api", action, err)
+	case errors.As(err, &transportErr):
+		return fmt.Sprintf("failed to %s: %v\n     check USG_DNS_URL (%s) and that the gateway is reachable from here", action, err, strings.Join(cfg.URLs, ","))
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized:
+		return fmt.Sprintf("failed to %s: usg-dns-api rejected the token\n     check USG_DNS_TOKEN or the content of USG_DNS_TOKEN_FILE, and that USG_DNS_AUTH_MODE (%s) is what usg-dns-api or its proxy expects", action, cfg.ClientAuthMode)
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden:
+		return fmt.Sprintf("failed to %s: the token is not allowed to do it\n     give the token the required permission on usg-dns-api", action)
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		return fmt.Sprintf("failed to %s: %v\n     check that USG_DNS_URL (%s) points to usg-dns-api, without a path", action, err, strings.Join(cfg.URLs, ","))
+	default:
+		return fmt.Sprintf("failed to %s: %v", action, err)
+	}
+}
+
+func runAudit(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	path := os.Getenv("AUDIT_LOG_FILE")
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "No audit log given and AUDIT_LOG_FILE is not set")
+		return 2
+	}
+
+	count, err := audit.Verify(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log is corrupted after %d valid entries: %v\n", count, err)
+		return 1
+	}
+
+	fmt.Printf("Audit log is intact: %d entries checked\n", count)
+	return 0
+}
+
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	exportFormat := flags.String("format", format.Zone, "output format: "+strings.Join(format.Formats, ", "))
+	dir := flags.String("dir", "", "write one zone file per zone to this directory")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *dir != "" && *exportFormat != format.Zone {
+		fmt.Fprintln(os.Stderr, "-dir is only supported with the zone format")
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun)
+	endpoints, err := prov.GetRecords(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get records: %v\n", err)
+		return 1
+	}
+	endpoints = diff.Filter(endpoints, prov.Manages)
+
+	if *dir == "" {
+		if err := format.Render(os.Stdout, *exportFormat, endpoints, cfg.DomainFilter); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export records: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	for _, zone := range format.SplitZones(endpoints, cfg.DomainFilter) {
+		name := zone.Name
+		if name == "" {
+			name = "root"
+		}
+		path := filepath.Join(*dir, name+".zone")
+		if err := writeZoneFile(path, zone); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Printf("Wrote %d records to %s\n", len(zone.Endpoints), path)
+	}
+	return 0
+}
+
+func writeZoneFile(path string, zone format.ZoneRecords) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := format.RenderZone(file, zone); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	importFormat := flags.String("format", "", "input format: "+strings.Join(format.ParseFormats, ", ")+" (default: from the file extension)")
+	conflict := flags.String("conflict", diff.ConflictSkip, "what to do with existing names: "+strings.Join(diff.ConflictStrategies, ", "))
+	dryRun := flags.Bool("dry-run", false, "only show the changes")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	path := flags.Arg(0)
+	if *importFormat == "" {
+		*importFormat = formatFromExtension(path)
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	input := os.Stdin
+	if path != "-" {
+		if input, err = os.Open(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", path, err)
+			return 1
+		}
+		defer input.Close()
+	}
+
+	endpoints, warnings, err := format.Parse(input, *importFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse %s: %v\n", path, err)
+		return 1
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	prov, closeProvider, err := commandProvider(cfg, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer closeProvider()
+
+	// Same normalization and checks as the endpoints sent by external-dns
+	endpoints, err = prov.AdjustEndpoints(endpoints)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to adjust endpoints: %v\n", err)
+		return 1
+	}
+	if problems := webhook.ValidateEndpoints(endpoints); len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Invalid records: %s\n", strings.Join(problems, "; "))
+		return 1
+	}
+	managed := diff.Filter(endpoints, prov.Manages)
+	if skipped := len(endpoints) - len(managed); skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d names outside of the domain filter\n", skipped)
+	}
+	managed, warnings = diff.SingleTarget(managed)
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	records, err := prov.RefreshCache(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get records: %v\n", err)
+		return 1
+	}
+	current := diff.Filter(provider.Endpoints(records), prov.Manages)
+
+	changes, conflicts, err := diff.Merge(current, managed, *conflict)
+	for _, c := range conflicts {
+		fmt.Fprintf(os.Stderr, "Conflict: %s is %s, file has %s\n", c.Name, strings.Join(c.Current, ","), strings.Join(c.Incoming, ","))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import aborted: %v\n", err)
+		return 1
+	}
+
+	printChanges(os.Stdout, changes)
+	switch {
+	case changes.IsEmpty():
+		fmt.Println("Nothing to import")
+		return 0
+	case *dryRun:
+		fmt.Println("Dry run: nothing was imported")
+		return 0
+	}
+
+	ctx := provider.WithCaller(context.Background(), commandCaller)
+	results, err := prov.ApplyChanges(ctx, changes)
+	if err != nil {
+		printResults(os.Stderr, results)
+		fmt.Fprintf(os.Stderr, "Failed to import records: %v\n", err)
+		return 1
+	}
+
+	printResults(os.Stdout, results)
+	return 0
+}
+
+// formatFromExtension guesses the format of a file from its extension,
+// falling back to the hosts format
+func formatFromExtension(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zone", ".db":
+		return format.Zone
+	case ".json":
+		return format.JSON
+	case ".yaml", ".yml":
+		return format.YAML
+	default:
+		return format.Hosts
+	}
+}
+
+func runPlan(args []string) int {
+	flags := flag.NewFlagSet("plan", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	asJSON := flags.Bool("json", false, "print the operations as JSON")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	path := flags.Arg(0)
+	input := os.Stdin
+	if path != "-" {
+		if input, err = os.Open(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open %s: %v\n", path, err)
+			return 1
+		}
+		defer input.Close()
+	}
+
+	var changes webhook.Changes
+	if err := json.NewDecoder(input).Decode(&changes); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid changes payload: %v\n", err)
+		return 1
+	}
+	if problems := changes.Validate(); len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "The webhook would reject these changes with a 400: %s\n", strings.Join(problems, "; "))
+		return 1
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun, provider.WithMaxDeletions(cfg.MaxDeletions))
+	operations, err := prov.Plan(context.Background(), &changes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to plan changes: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(operations); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode operations: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	printOperations(os.Stdout, operations)
+	return 0
+}
+
+// printOperations writes one line per planned operation. As ApplyChanges
+// stops at the first failure, the following operations are reported as not
+// attempted.
+func printOperations(w io.Writer, operations []provider.PlannedOperation) {
+	failed := false
+	for _, op := range operations {
+		line := fmt.Sprintf("%-6s %s: ", op.Action, op.DNSName)
+		switch {
+		case failed:
+			fmt.Fprintln(w, line+"not attempted after an earlier failure")
+			continue
+		case op.Outcome == provider.OutcomeApply:
+			line += op.Method + " " + op.Path
+			if op.CurrentTarget != "" && op.Action != provider.ActionCreate {
+				line += " (currently " + op.CurrentTarget + ")"
+			}
+			if op.Target != "" {
+				line += " -> " + op.Target
+			}
+		case op.Outcome == provider.OutcomeNoop:
+			line += "nothing to do"
+		case op.Outcome == provider.OutcomeFail:
+			line += "FAILS"
+			failed = true
+		}
+		if len(op.DroppedTargets) > 0 {
+			line += ", dropping " + strings.Join(op.DroppedTargets, ", ")
+		}
+		if op.Reason != "" {
+			line += " [" + op.Reason + "]"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+func runReconcile(args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	once := flags.Bool("once", false, "reconcile a single time and exit")
+	interval := flags.Duration("interval", 5*time.Minute, "how often to reconcile")
+	dryRun := flags.Bool("dry-run", false, "only show the changes, implies -once")
+	allowEmpty := flags.Bool("allow-empty", false, "allow an empty file to delete every record")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 || *interval <= 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	path := flags.Arg(0)
+	fileFormat := formatFromExtension(path)
+	if fileFormat != format.JSON {
+		fileFormat = format.YAML
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	prov, closeProvider, err := commandProvider(cfg, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer closeProvider()
+
+	reconciler := reconcile.New(prov, path, fileFormat)
+	reconciler.AllowEmpty = *allowEmpty
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if !*once && !*dryRun {
+		log.Printf("Reconciling records with %s every %s", path, *interval)
+		reconciler.Run(ctx, *interval)
+		return 0
+	}
+
+	changes, results, err := reconciler.Once(ctx, *dryRun)
+	if changes != nil {
+		printChanges(os.Stdout, changes)
+	}
+	if err != nil {
+		printResults(os.Stderr, results)
+		fmt.Fprintf(os.Stderr, "Failed to reconcile records: %v\n", err)
+		return 1
+	}
+
+	switch {
+	case changes.IsEmpty():
+		fmt.Println("Records match the desired state")
+	case *dryRun:
+		fmt.Println("Dry run: nothing was changed")
+	default:
+		printResults(os.Stdout, results)
+	}
+	return 0
+}
+
+func runRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	list := flags.Bool("list", false, "list the snapshots")
+	dryRun := flags.Bool("dry-run", false, "only show the changes")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if !*list && flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	if cfg.SnapshotDir == "" {
+		fmt.Fprintln(os.Stderr, "SNAPSHOT_DIR is not set")
+		return 2
+	}
+
+	store, err := snapshot.NewStore(cfg.SnapshotDir, cfg.SnapshotKeep, cfg.SnapshotMaxAge)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open snapshot directory: %v\n", err)
+		return 1
+	}
+
+	if *list {
+		snapshots, err := store.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list snapshots: %v\n", err)
+			return 1
+		}
+		for _, info := range snapshots {
+			fmt.Printf("%s\t%d records\t%s\n", info.ID, info.Records, info.RequestID)
+		}
+		return 0
+	}
+
+	snap, err := store.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load snapshot: %v\n", err)
+		return 1
+	}
+
+	prov, closeProvider, err := commandProvider(cfg, store)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer closeProvider()
+
+	ctx := provider.WithCaller(context.Background(), commandCaller)
+	changes, results, err := snapshot.Restore(ctx, prov, snap, *dryRun)
+	if changes != nil {
+		printChanges(os.Stdout, changes)
+	}
+	if err != nil {
+		printResults(os.Stderr, results)
+		fmt.Fprintf(os.Stderr, "Failed to restore snapshot %s: %v\n", snap.ID, err)
+		return 1
+	}
+
+	switch {
+	case changes.IsEmpty():
+		fmt.Printf("Records already match snapshot %s\n", snap.ID)
+	case *dryRun:
+		fmt.Printf("Dry run: snapshot %s was not restored\n", snap.ID)
+	default:
+		fmt.Printf("Restored snapshot %s\n", snap.ID)
+	}
+	return 0
+}
+
+// commandProvider creates a provider for commands changing records. Like
+// the server, it enforces MAX_DELETIONS, records changes in the audit log
+// and snapshots records before changing them, when those are enabled.
+func commandProvider(cfg *config.Config, snapshots *snapshot.Store) (*provider.Provider, func(), error) {
+	opts := []provider.Option{provider.WithMaxDeletions(cfg.MaxDeletions)}
+	closeProvider := func() {}
+
+	if cfg.AuditLogFile != "" {
+		auditLog, err := audit.Open(cfg.AuditLogFile, cfg.AuditLogMaxBytes, cfg.AuditLogMaxBackups)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		opts = append(opts, provider.WithObserver(auditLog))
+		closeProvider = func() { auditLog.Close() }
+	}
+
+	if snapshots == nil && cfg.SnapshotDir != "" {
+		var err error
+		if snapshots, err = snapshot.NewStore(cfg.SnapshotDir, cfg.SnapshotKeep, cfg.SnapshotMaxAge); err != nil {
+			closeProvider()
+			return nil, nil, fmt.Errorf("failed to open snapshot directory: %w", err)
+		}
+	}
+	if snapshots != nil {
+		opts = append(opts, provider.WithSnapshotter(snapshots))
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		closeProvider()
+		return nil, nil, err
+	}
+	return provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun, opts...), closeProvider, nil
+}
+
+// printChanges writes one line per change: + for creates, ~ for updates and
+// - for deletes
+func printChanges(w io.Writer, changes *webhook.Changes) {
+	for _, endpoint := range changes.Create {
+		fmt.Fprintf(w, "+ %s %s\n", endpoint.DNSName, strings.Join(endpoint.Targets, ","))
+	}
+	for i, endpoint := range changes.UpdateNew {
+		var old []string
+		if i < len(changes.UpdateOld) {
+			old = changes.UpdateOld[i].Targets
+		}
+		fmt.Fprintf(w, "~ %s %s -> %s\n", endpoint.DNSName, strings.Join(old, ","), strings.Join(endpoint.Targets, ","))
+	}
+	for _, endpoint := range changes.Delete {
+		fmt.Fprintf(w, "- %s %s\n", endpoint.DNSName, strings.Join(endpoint.Targets, ","))
+	}
+}
+
+// printResults writes the outcome of each change
+func printResults(w io.Writer, results []provider.ChangeResult) {
+	for _, result := range results {
+		if result.Reason != "" {
+			fmt.Fprintf(w, "%s %s: %s (%s)\n", result.Action, result.DNSName, result.Status, result.Reason)
+			continue
+		}
+		fmt.Fprintf(w, "%s %s: %s\n", result.Action, result.DNSName, result.Status)
+	}
+}
@@ -1,41 +1,253 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/audit"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/auth"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/config"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/events"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/filewatch"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/metrics"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/notify"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/reload"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/replicate"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/server"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/snapshot"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/tlsutil"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/tracing"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/version"
 )
 
 func main() {
-	log.Printf("Starting external-dns-usg-dns-api %s", version.VersionFull())
+	// Arguments other than flags name a command
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1:]))
+	}
 
 	// Load configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
+	cfg, err := config.Load(os.Args[1:])
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		printUsage(os.Stdout)
+		os.Exit(0)
+	case errors.Is(err, config.ErrVersion):
+		fmt.Println(version.VersionFull())
+		os.Exit(0)
+	case err != nil:
 		log.Fatalf("Failed to load configuration: %v", err)
-		os.Exit(1)
 	}
 
+	log.Printf("Starting external-dns-usg-dns-api %s", version.VersionFull())
+
 	log.Printf("Configuration loaded:")
-	log.Printf("  USG DNS URL: %s", cfg.URL)
+	log.Printf("  USG DNS URL: %s (write mode: %s)", strings.Join(cfg.URLs, ", "), cfg.WriteMode)
+	log.Printf("  USG DNS Authentication: %s", cfg.ClientAuthMode)
+	log.Printf("  Replication: every %s, %s mode, %s authority", cfg.ReplicationInterval, cfg.ReplicationMode, cfg.ReplicationAuthority)
+	log.Printf("  USG DNS Rate Limit: %g/s (burst %d), circuit breaker after %d failures for %s", cfg.RateLimit, cfg.RateBurst, cfg.BreakerThreshold, cfg.BreakerCooldown)
+	log.Printf("  USG DNS TLS: CA file %q, client certificate: %v, pins: %d, skip verify: %v", cfg.CAFile, cfg.ClientCertFile != "", len(cfg.Pins), cfg.InsecureSkipVerify)
 	log.Printf("  Domain Filter: %v", cfg.DomainFilter)
+	log.Printf("  API Bind Address: %s", cfg.BindAddress)
 	log.Printf("  API Port: %d", cfg.Port)
+	log.Printf("  API TLS: %v (client certificates: %v)", cfg.TLSEnabled(), cfg.TLSClientCAFile != "")
 	log.Printf("  Health Port: %d", cfg.HealthPort)
+	log.Printf("  API Authentication: %s", cfg.AuthMode)
+	log.Printf("  Strict Validation: %v", cfg.StrictValidation)
 	log.Printf("  Dry Run: %v", cfg.DryRun)
+	log.Printf("  Cache TTL: %s", cfg.CacheTTL)
+	log.Printf("  Max Deletions: %d", cfg.MaxDeletions)
+	log.Printf("  Admin Port: %d", cfg.AdminPort)
+	log.Printf("  Monitor Interval: %s", cfg.MonitorInterval)
+	log.Printf("  Dashboard Port: %d", cfg.DashboardPort)
+	log.Printf("  Notifications: %v", cfg.NotifyEnabled())
+	log.Printf("  Audit Log: %s", cfg.AuditLogFile)
+	log.Printf("  Snapshot Directory: %s", cfg.SnapshotDir)
+	log.Printf("  Tracing Exporter: %s", cfg.TracingExporter)
+
+	// Set up tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		Insecure:    cfg.TracingInsecure,
+		SampleRatio: cfg.TracingSampleRatio,
+		ServiceName: cfg.TracingServiceName,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	// Flush pending spans on termination
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		log.Printf("Shutting down")
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Failed to shut down tracing: %v", err)
+		}
+		os.Exit(0)
+	}()
 
 	// Create USG DNS API client
-	client := usgdns.NewClient(cfg.URL, cfg.Token)
+	if cfg.InsecureSkipVerify {
+		log.Printf("WARNING: USG_DNS_INSECURE_SKIP_VERIFY is set, the certificate of usg-dns-api is not verified and anyone on the network path can impersonate it and read the token")
+	}
+	client, err := newClient(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	go client.WatchToken(context.Background(), filewatch.DefaultInterval)
+	go client.WatchTLS(context.Background(), filewatch.DefaultInterval)
+	go client.WatchBackends(context.Background(), cfg.HealthInterval)
 
 	// Create provider
-	prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun)
+	providerOpts := []provider.Option{
+		provider.WithCacheTTL(cfg.CacheTTL),
+		provider.WithMaxDeletions(cfg.MaxDeletions),
+	}
+
+	if cfg.NotifyEnabled() {
+		var sinks []notify.Sink
+		if cfg.NotifyHTTPURL != "" {
+			sinks = append(sinks, notify.NewHTTPSink(cfg.NotifyHTTPURL, cfg.NotifyRetries))
+		}
+		if cfg.NotifySlackURL != "" {
+			sinks = append(sinks, notify.NewSlackSink(cfg.NotifySlackURL, cfg.NotifyRetries))
+		}
+		if cfg.NotifyFile != "" {
+			sinks = append(sinks, notify.NewFileSink(cfg.NotifyFile))
+		}
+		notifier := notify.New(notify.Filter{Domains: cfg.NotifyDomains, Events: cfg.NotifyEvents}, sinks...)
+		notifier.Start(context.Background())
+		providerOpts = append(providerOpts, provider.WithObserver(notifier))
+	}
+
+	if cfg.AuditLogFile != "" {
+		auditLog, err := audit.Open(cfg.AuditLogFile, cfg.AuditLogMaxBytes, cfg.AuditLogMaxBackups)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		providerOpts = append(providerOpts, provider.WithObserver(auditLog))
+	}
+
+	var snapshots *snapshot.Store
+	if cfg.SnapshotDir != "" {
+		snapshots, err = snapshot.NewStore(cfg.SnapshotDir, cfg.SnapshotKeep, cfg.SnapshotMaxAge)
+		if err != nil {
+			log.Fatalf("Failed to open snapshot directory: %v", err)
+		}
+		providerOpts = append(providerOpts, provider.WithSnapshotter(snapshots))
+	}
+
+	// Events are streamed on the admin API
+	var broker *events.Broker
+	var monitor *events.Monitor
+	if cfg.AdminPort > 0 {
+		broker = events.NewBroker(cfg.EventsReplaySize)
+		providerOpts = append(providerOpts, provider.WithObserver(broker))
+	}
+
+	prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun, providerOpts...)
+
+	if broker != nil && cfg.MonitorInterval > 0 {
+		monitor = events.NewMonitor(prov, broker, cfg.MonitorInterval)
+		prov.AddObserver(monitor)
+		go monitor.Run(context.Background())
+	}
+
+	// Replicate the records of the primary usg-dns-api to the others
+	registry := clientMetrics(client)
+	if cfg.ReplicationInterval > 0 {
+		if cfg.ReplicationMode == replicate.ModeOneWay {
+			log.Printf("WARNING: REPLICATION_MODE is one-way, the records created on a secondary usg-dns-api while %s was down are deleted once it answers again; use REPLICATION_MODE merge to keep them, though merge copies deleted records back", cfg.URLs[0])
+		}
+		replicators, err := newReplicators(cfg, prov.Manages, prov.DryRun)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, replicator := range replicators {
+			go replicator.Run(context.Background(), cfg.ReplicationInterval)
+		}
+		replicationMetrics(registry, replicators)
+	}
 
 	// Create and start server
-	srv := server.NewServer(prov, cfg.Port, cfg.HealthPort)
+	serverOpts := []server.Option{
+		server.WithBindAddress(cfg.BindAddress),
+		server.WithMaxBodyBytes(cfg.MaxRequestBodyBytes),
+		server.WithStrictDecoding(cfg.StrictValidation),
+	}
+	if cfg.TLSEnabled() {
+		tlsConfig, err := tlsutil.NewServerConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS configuration: %v", err)
+		}
+		go tlsConfig.Watch(context.Background(), filewatch.DefaultInterval)
+		serverOpts = append(serverOpts, server.WithTLS(tlsConfig.TLSConfig()))
+	}
+	switch cfg.AuthMode {
+	case auth.ModeBearer:
+		serverOpts = append(serverOpts, server.WithAuthenticator(auth.NewBearerToken(cfg.AuthToken)))
+	case auth.ModeHMAC:
+		serverOpts = append(serverOpts, server.WithAuthenticator(auth.NewHMAC(cfg.AuthHMACSecret, cfg.AuthMaxSkew)))
+	}
+	if cfg.AdminPort > 0 {
+		serverOpts = append(serverOpts,
+			server.WithAdmin(cfg.AdminBindAddress, cfg.AdminPort, auth.NewBearerToken(cfg.AdminToken)),
+			server.WithEvents(broker),
+		)
+		if snapshots != nil {
+			serverOpts = append(serverOpts, server.WithSnapshots(snapshots))
+		}
+	}
+	if cfg.DashboardPort > 0 {
+		serverOpts = append(serverOpts, server.WithDashboard(cfg.DashboardBindAddress, cfg.DashboardPort))
+		if cfg.DashboardTLSCertFile != "" {
+			tlsConfig, err := tlsutil.NewServerConfig(cfg.DashboardTLSCertFile, cfg.DashboardTLSKeyFile, "")
+			if err != nil {
+				log.Fatalf("Failed to load dashboard TLS configuration: %v", err)
+			}
+			go tlsConfig.Watch(context.Background(), filewatch.DefaultInterval)
+			serverOpts = append(serverOpts, server.WithDashboardTLS(tlsConfig.TLSConfig()))
+		}
+	}
+	serverOpts = append(serverOpts,
+		server.WithReadiness(client.Ready),
+		server.WithMetrics(registry),
+	)
+	srv := server.NewServer(prov, cfg.Port, cfg.HealthPort, serverOpts...)
+
+	// Reload the runtime settings on SIGHUP or config file change
+	var reloadOpts []reload.Option
+	if broker != nil {
+		reloadOpts = append(reloadOpts, reload.WithPublisher(broker))
+	}
+	reloader := reload.New(cfg, func() (*config.Config, error) {
+		return config.Load(os.Args[1:])
+	}, func(next *config.Config, changed []string) {
+		// Keep a dry-run mode toggled on the admin API unless DRY_RUN changed
+		dryRun := prov.DryRun()
+		if slices.Contains(changed, "DRY_RUN") {
+			dryRun = next.DryRun
+		}
+		prov.Reconfigure(provider.Settings{DomainFilter: next.DomainFilter, DryRun: dryRun, MaxDeletions: next.MaxDeletions})
+		srv.SetPolicy(server.Policy{MaxBodyBytes: next.MaxRequestBodyBytes, StrictDecoding: next.StrictValidation})
+		if slices.Contains(changed, "USG_DNS_TOKEN") {
+			client.SetToken(next.Token)
+		}
+	}, reloadOpts...)
+	go reloader.Run(context.Background(), cfg.File, filewatch.DefaultInterval)
 
 	log.Printf("API server listening on port %d", cfg.Port)
 	log.Printf("Health server listening on port %d", cfg.HealthPort)
@@ -44,3 +256,49 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// clientMetrics registers the failover, rate limiter and circuit breaker
+// metrics of the usg-dns-api client, labeled with the URL of each backend
+func clientMetrics(client *usgdns.Client) *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.GaugeVec("usgdns_backend_active", "Whether the backend serves reads.", backendSamples(client, func(b usgdns.BackendStats) float64 {
+		return boolValue(b.Active)
+	}))
+	registry.GaugeVec("usgdns_backend_healthy", "Whether the backend answered the last request sent to it.", backendSamples(client, func(b usgdns.BackendStats) float64 {
+		return boolValue(b.Healthy)
+	}))
+	registry.Counter("usgdns_backend_switches_total", "Times reads moved to another backend.", func() float64 {
+		return float64(client.Stats().Switches)
+	})
+	registry.GaugeVec("usgdns_circuit_breaker_state", "State of the circuit breaker: 0 closed, 1 half-open, 2 open.", backendSamples(client, func(b usgdns.BackendStats) float64 {
+		return float64(b.BreakerState)
+	}))
+	registry.CounterVec("usgdns_circuit_breaker_opened_total", "Times the circuit breaker opened.", backendSamples(client, func(b usgdns.BackendStats) float64 {
+		return float64(b.BreakerOpened)
+	}))
+	registry.CounterVec("usgdns_circuit_breaker_rejected_requests_total", "Requests failed without being sent while the circuit breaker was open.", backendSamples(client, func(b usgdns.BackendStats) float64 {
+		return float64(b.BreakerRejected)
+	}))
+	registry.CounterVec("usgdns_rate_limited_requests_total", "Requests which waited for the rate limiter.", backendSamples(client, func(b usgdns.BackendStats) float64 {
+		return float64(b.RateLimited)
+	}))
+	return registry
+}
+
+// backendSamples returns a sample of value per backend of the client
+func backendSamples(client *usgdns.Client, value func(usgdns.BackendStats) float64) func() []metrics.Sample {
+	return func() []metrics.Sample {
+		var samples []metrics.Sample
+		for _, b := range client.Stats().Backends {
+			samples = append(samples, metrics.Sample{Labels: map[string]string{"url": b.URL}, Value: value(b)})
+		}
+		return samples
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/config"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/selector"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// Output formats of records list and records get
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+func runRecords(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		return runRecordsList(args[1:])
+	case "get":
+		return runRecordsGet(args[1:])
+	case "create":
+		return runRecordsCreate(args[1:])
+	case "update":
+		return runRecordsUpdate(args[1:])
+	case "delete":
+		return runRecordsDelete(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown records command %q\n\n", args[0])
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+}
+
+func runRecordsList(args []string) int {
+	flags := flag.NewFlagSet("records list", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	output := outputFlag(flags)
+	nameGlob := flags.String("name", "", "only list the records whose name matches this glob, such as *.example.com")
+	targetCIDR := flags.String("target", "", "only list the records whose target is within this CIDR")
+	all := flags.Bool("all", false, "also list the records outside the domain filter")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	if !validOutput(*output) {
+		fmt.Fprintf(os.Stderr, "Invalid -output %q, expected table, json or yaml\n", *output)
+		return 2
+	}
+	sel, err := selector.Parse(*nameGlob, *targetCIDR)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	records, err := client.GetRecords(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get records: %v\n", err)
+		return 1
+	}
+	if !*all {
+		prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun)
+		records = managedRecords(records, prov.Manages)
+	}
+	records = sel.Filter(records)
+
+	if err := printRecords(os.Stdout, *output, records); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print records: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runRecordsGet(args []string) int {
+	flags := flag.NewFlagSet("records get", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	output := outputFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	if !validOutput(*output) {
+		fmt.Fprintf(os.Stderr, "Invalid -output %q, expected table, json or yaml\n", *output)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	records, err := client.GetRecords(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get records: %v\n", err)
+		return 1
+	}
+
+	// The argument is either the ID of a record or a name, which can have
+	// several records
+	var found []usgdns.Record
+	for _, record := range records {
+		if record.ID == flags.Arg(0) || record.Name == flags.Arg(0) {
+			found = append(found, record)
+		}
+	}
+	if len(found) == 0 {
+		fmt.Fprintf(os.Stderr, "No record named or with ID %q\n", flags.Arg(0))
+		return 1
+	}
+
+	if err := printRecords(os.Stdout, *output, found); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print records: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runRecordsCreate(args []string) int {
+	flags := flag.NewFlagSet("records create", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	dryRun := flags.Bool("dry-run", false, "only show what would be done")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	name, target := flags.Arg(0), flags.Arg(1)
+	if net.ParseIP(target).To4() == nil {
+		fmt.Fprintf(os.Stderr, "Invalid target %q: expected an IPv4 address\n", target)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	changes := &webhook.Changes{
+		Create: []*webhook.Endpoint{{DNSName: name, Targets: []string{target}, RecordType: "A"}},
+	}
+	return applyRecordChanges(cfg, changes, *dryRun)
+}
+
+func runRecordsUpdate(args []string) int {
+	flags := flag.NewFlagSet("records update", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	dryRun := flags.Bool("dry-run", false, "only show what would be done")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	ref, target := flags.Arg(0), flags.Arg(1)
+	if net.ParseIP(target).To4() == nil {
+		fmt.Fprintf(os.Stderr, "Invalid target %q: expected an IPv4 address\n", target)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	records, err := client.GetRecords(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get records: %v\n", err)
+		return 1
+	}
+
+	// The argument is either the ID of a record or a name, which must then
+	// have a single record
+	var found []usgdns.Record
+	for _, record := range records {
+		if record.ID == ref {
+			found = []usgdns.Record{record}
+			break
+		}
+		if record.Name == ref {
+			found = append(found, record)
+		}
+	}
+	switch len(found) {
+	case 0:
+		fmt.Fprintf(os.Stderr, "No record named or with ID %q, use records create\n", ref)
+		return 1
+	case 1:
+	default:
+		ids := make([]string, len(found))
+		for i, record := range found {
+			ids[i] = record.ID + " (" + record.Target + ")"
+		}
+		fmt.Fprintf(os.Stderr, "%s has %d records, give the ID of the one to update: %s\n", ref, len(found), strings.Join(ids, ", "))
+		return 1
+	}
+	current := found[0]
+
+	changes := &webhook.Changes{
+		UpdateOld: []*webhook.Endpoint{{DNSName: current.Name, Targets: []string{current.Target}, RecordType: "A"}},
+		UpdateNew: []*webhook.Endpoint{{DNSName: current.Name, Targets: []string{target}, RecordType: "A"}},
+	}
+	return applyRecordChanges(cfg, changes, *dryRun)
+}
+
+func runRecordsDelete(args []string) int {
+	flags := flag.NewFlagSet("records delete", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	dryRun := flags.Bool("dry-run", false, "only show what would be done")
+	nameGlob := flags.String("name", "", "delete the records whose name matches this glob, such as *.example.com")
+	targetCIDR := flags.String("target", "", "delete the records whose target is within this CIDR")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	sel, err := selector.Parse(*nameGlob, *targetCIDR)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	// Without names nor a selector, every record would be deleted
+	if flags.NArg() == 0 && sel.IsEmpty() {
+		fmt.Fprintln(os.Stderr, "Give the names to delete, or select them with -name or -target")
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	records, err := client.GetRecords(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get records: %v\n", err)
+		return 1
+	}
+	prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun)
+	records = sel.Filter(managedRecords(records, prov.Manages))
+
+	// Names given on the command line restrict the selected records
+	if flags.NArg() > 0 {
+		names := make(map[string]bool, flags.NArg())
+		for _, name := range flags.Args() {
+			if !prov.Manages(name) {
+				fmt.Fprintf(os.Stderr, "%s is outside the domain filter\n", name)
+				return 1
+			}
+			names[name] = true
+		}
+		var named []usgdns.Record
+		for _, record := range records {
+			if names[record.Name] {
+				named = append(named, record)
+			}
+		}
+		records = named
+	}
+
+	if len(records) == 0 {
+		fmt.Println("No record to delete")
+		return 0
+	}
+
+	changes := &webhook.Changes{}
+	for _, record := range records {
+		changes.Delete = append(changes.Delete, &webhook.Endpoint{
+			DNSName:    record.Name,
+			Targets:    []string{record.Target},
+			RecordType: "A",
+		})
+	}
+	return applyRecordChanges(cfg, changes, *dryRun)
+}
+
+// applyRecordChanges applies changes through the provider, as the webhook
+// does, so that the domain filter, DRY_RUN, MAX_DELETIONS, the audit log and
+// snapshots apply to them. With dryRun, the planned operations are printed
+// instead.
+func applyRecordChanges(cfg *config.Config, changes *webhook.Changes, dryRun bool) int {
+	if problems := changes.Validate(); len(problems) > 0 {
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		return 2
+	}
+
+	prov, closeProvider, err := commandProvider(cfg, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	defer closeProvider()
+
+	for _, endpoint := range append(changes.Create, changes.UpdateNew...) {
+		if !prov.Manages(endpoint.DNSName) {
+			fmt.Fprintf(os.Stderr, "%s is outside the domain filter\n", endpoint.DNSName)
+			return 1
+		}
+	}
+
+	ctx := provider.WithCaller(context.Background(), commandCaller)
+	if dryRun {
+		operations, err := prov.Plan(ctx, changes)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to plan changes: %v\n", err)
+			return 1
+		}
+		printOperations(os.Stdout, operations)
+		fmt.Println("Dry run: nothing was changed")
+		return 0
+	}
+
+	results, err := prov.ApplyChanges(ctx, changes)
+	if err != nil {
+		printResults(os.Stderr, results)
+		fmt.Fprintf(os.Stderr, "Failed to apply changes: %v\n", err)
+		return 1
+	}
+	printResults(os.Stdout, results)
+	return 0
+}
+
+// outputFlag adds the -output flag of the commands printing records
+func outputFlag(flags *flag.FlagSet) *string {
+	return flags.String("output", outputTable, "output format: table, json or yaml")
+}
+
+// validOutput reports whether output is a format printRecords supports
+func validOutput(output string) bool {
+	return output == outputTable || output == outputJSON || output == outputYAML
+}
+
+// managedRecords returns the records within the domain filter
+func managedRecords(records []usgdns.Record, manages func(name string) bool) []usgdns.Record {
+	var managed []usgdns.Record
+	for _, record := range records {
+		if manages(record.Name) {
+			managed = append(managed, record)
+		}
+	}
+	return managed
+}
+
+// printRecords writes records sorted by name, then target
+func printRecords(w io.Writer, output string, records []usgdns.Record) error {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return records[i].Target < records[j].Target
+	})
+	if records == nil {
+		records = []usgdns.Record{}
+	}
+
+	switch output {
+	case outputTable:
+		table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "ID\tNAME\tTARGET")
+		for _, record := range records {
+			fmt.Fprintf(table, "%s\t%s\t%s\n", record.ID, record.Name, record.Target)
+		}
+		return table.Flush()
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case outputYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		return fmt.Errorf("unknown output format %q, expected table, json or yaml", output)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/config"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/metrics"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/replicate"
+)
+
+func runReplicate(args []string) int {
+	flags := flag.NewFlagSet("replicate", flag.ContinueOnError)
+	configFile := configFlag(flags)
+	once := flags.Bool("once", false, "replicate a single time and exit")
+	interval := flags.Duration("interval", 0, "how often to replicate (default: $REPLICATION_INTERVAL, or 1m)")
+	dryRun := flags.Bool("dry-run", false, "only show the changes, implies -once")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 || *interval < 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	if len(cfg.URLs) < 2 {
+		fmt.Fprintln(os.Stderr, "Replication needs a secondary URL in USG_DNS_URL")
+		return 2
+	}
+	if *interval == 0 {
+		*interval = cfg.ReplicationInterval
+	}
+	if *interval == 0 {
+		*interval = time.Minute
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	prov := provider.NewProvider(client, cfg.DomainFilter, cfg.DryRun)
+
+	replicators, err := newReplicators(cfg, prov.Manages, func() bool { return *dryRun || cfg.DryRun })
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if !*once && !*dryRun {
+		log.Printf("Replicating records from %s every %s", cfg.URLs[0], *interval)
+		var wg sync.WaitGroup
+		for _, replicator := range replicators {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				replicator.Run(ctx, *interval)
+			}()
+		}
+		wg.Wait()
+		return 0
+	}
+
+	status := 0
+	for _, replicator := range replicators {
+		plan, err := replicator.Once(ctx, *dryRun || cfg.DryRun)
+		if plan != nil {
+			printReplicationPlan(os.Stdout, plan)
+		}
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Failed to replicate records to %s: %v\n", replicator.Secondary(), err)
+			status = 1
+		case plan.IsEmpty():
+			fmt.Printf("Records of %s match %s\n", replicator.Secondary(), cfg.URLs[0])
+		case *dryRun || cfg.DryRun:
+			fmt.Println("Dry run: nothing was changed")
+		default:
+			fmt.Printf("Replicated %d changes to %s\n", len(plan.Operations), replicator.Secondary())
+		}
+	}
+	return status
+}
+
+// newReplicators creates a replicator from the first URL of USG_DNS_URL to
+// each of the others. Each instance gets its own client, so that
+// replication stops rather than fails over while one is down.
+func newReplicators(cfg *config.Config, manages func(string) bool, dryRun func() bool) ([]*replicate.Replicator, error) {
+	instances := make([]replicate.Instance, len(cfg.URLs))
+	for i, u := range cfg.URLs {
+		single := *cfg
+		single.URLs = []string{u}
+		client, err := newClient(&single)
+		if err != nil {
+			return nil, err
+		}
+		instances[i] = replicate.Instance{URL: u, Client: client}
+	}
+
+	opts := replicate.Options{
+		Mode:         cfg.ReplicationMode,
+		Authority:    cfg.ReplicationAuthority,
+		Manages:      manages,
+		MaxDeletions: cfg.MaxDeletions,
+		DryRun:       dryRun,
+	}
+	var replicators []*replicate.Replicator
+	for _, secondary := range instances[1:] {
+		replicators = append(replicators, replicate.New(instances[0], secondary, opts))
+	}
+	return replicators, nil
+}
+
+// printReplicationPlan writes one line per operation, + for creates and -
+// for deletes, then one line per conflict
+func printReplicationPlan(w io.Writer, plan *replicate.Plan) {
+	for _, op := range plan.Operations {
+		sign := "+"
+		if op.Action == replicate.ActionDelete {
+			sign = "-"
+		}
+		fmt.Fprintf(w, "%s %s %s on %s\n", sign, op.Record.Name, op.Record.Target, op.URL)
+	}
+	for _, conflict := range plan.Conflicts {
+		fmt.Fprintf(w, "! %s\n", conflict)
+	}
+}
+
+// replicationMetrics registers the lag and divergence of each replicator,
+// labeled with the URL of its secondary
+func replicationMetrics(registry *metrics.Registry, replicators []*replicate.Replicator) {
+	samples := func(value func(*replicate.Replicator) float64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			var samples []metrics.Sample
+			for _, r := range replicators {
+				samples = append(samples, metrics.Sample{Labels: map[string]string{"url": r.Secondary()}, Value: value(r)})
+			}
+			return samples
+		}
+	}
+
+	registry.GaugeVec("usgdns_replication_lag_seconds", "Time since the secondary was last known to hold the records of the primary.", samples(func(r *replicate.Replicator) float64 {
+		return r.Lag().Seconds()
+	}))
+	registry.GaugeVec("usgdns_replication_divergent_records", "Records created or deleted by the last replication pass to align the instances.", samples(func(r *replicate.Replicator) float64 {
+		return float64(r.Status().Divergence)
+	}))
+	registry.GaugeVec("usgdns_replication_conflicts", "Names with different targets on the instances found by the last replication pass.", samples(func(r *replicate.Replicator) float64 {
+		return float64(r.Status().Conflicts)
+	}))
+	registry.CounterVec("usgdns_replication_failures_total", "Replication passes which failed.", samples(func(r *replicate.Replicator) float64 {
+		return float64(r.Status().Failures)
+	}))
+}
@@ -0,0 +1,291 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+)
+
+// maxLineSize bounds the size of a single entry when reading the log back
+const maxLineSize = 1 << 20
+
+// Entry is a single audited change. Each entry carries the hash of the
+// previous one and its own hash covers every other field, so editing,
+// removing or reordering entries breaks the chain.
+type Entry struct {
+	Seq        uint64                `json:"seq"`
+	Time       time.Time             `json:"time"`
+	RequestID  string                `json:"requestId,omitempty"`
+	Caller     string                `json:"caller,omitempty"`
+	Action     provider.Action       `json:"action"`
+	DNSName    string                `json:"dnsName"`
+	OldTargets []string              `json:"oldTargets,omitempty"`
+	NewTargets []string              `json:"newTargets,omitempty"`
+	DryRun     bool                  `json:"dryRun"`
+	Status     provider.ChangeStatus `json:"status"`
+	Reason     string                `json:"reason,omitempty"`
+	PrevHash   string                `json:"prevHash"`
+	Hash       string                `json:"hash"`
+}
+
+// computeHash returns the hash of the entry, excluding its Hash field
+func (e Entry) computeHash() string {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		// Entries only hold strings, numbers and times
+		panic(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Logger appends entries to a JSONL file, rotating it once it grows over
+// maxBytes and keeping maxBackups rotated files (path.1 being the newest)
+type Logger struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	seq        uint64
+	lastHash   string
+}
+
+// Open opens the audit log at path, resuming the chain from its last entry
+func Open(path string, maxBytes int64, maxBackups int) (*Logger, error) {
+	l := &Logger{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxBackups: maxBackups,
+	}
+
+	// The current file may be empty right after a rotation
+	for _, name := range append([]string{path}, backupNames(path, maxBackups)...) {
+		last, err := lastEntry(name)
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			l.seq = last.Seq
+			l.lastHash = last.Hash
+			break
+		}
+	}
+
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Close closes the audit log
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.file.Close()
+}
+
+// ObserveBatch implements provider.Observer, recording every change of the
+// batch, including those which were skipped or failed
+func (l *Logger) ObserveBatch(_ context.Context, batch provider.Batch) {
+	for _, result := range batch.Results {
+		entry := Entry{
+			Time:       batch.ReceivedAt.UTC(),
+			RequestID:  batch.RequestID,
+			Caller:     batch.Caller,
+			Action:     result.Action,
+			DNSName:    result.DNSName,
+			OldTargets: result.OldTargets,
+			DryRun:     batch.DryRun,
+			Status:     result.Status,
+			Reason:     result.Reason,
+		}
+		if result.Action != provider.ActionDelete {
+			entry.NewTargets = result.Targets
+		}
+		if err := l.Append(entry); err != nil {
+			log.Printf("Failed to write audit entry for %s: %v", result.DNSName, err)
+		}
+	}
+}
+
+// Append chains entry to the previous one and writes it
+func (l *Logger) Append(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = l.seq + 1
+	entry.PrevHash = l.lastHash
+	entry.Hash = entry.computeHash()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	l.seq = entry.Seq
+	l.lastHash = entry.Hash
+	return nil
+}
+
+func (l *Logger) openFile() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest one, and moves the
+// current file to path.1
+func (l *Logger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+
+	if l.maxBackups == 0 {
+		if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return l.openFile()
+	}
+
+	backups := backupNames(l.path, l.maxBackups)
+	if err := os.Remove(backups[len(backups)-1]); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := len(backups) - 1; i > 0; i-- {
+		if err := os.Rename(backups[i-1], backups[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, backups[0]); err != nil {
+		return err
+	}
+	return l.openFile()
+}
+
+// Verify checks the chain of the audit log at path and of its rotated
+// files, and returns the number of entries checked. When the oldest files
+// were dropped by rotation, the chain is checked from the oldest entry left.
+func Verify(path string) (int, error) {
+	var names []string
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		names = append([]string{name}, names...)
+	}
+	names = append(names, path)
+
+	var count int
+	var prev *Entry
+	for _, name := range names {
+		err := readEntries(name, func(line int, entry Entry) error {
+			if hash := entry.computeHash(); hash != entry.Hash {
+				return fmt.Errorf("%s:%d: entry %d was altered: hash is %s, expected %s", name, line, entry.Seq, entry.Hash, hash)
+			}
+			switch {
+			case prev == nil && entry.Seq == 1 && entry.PrevHash != "":
+				return fmt.Errorf("%s:%d: first entry refers to a previous entry", name, line)
+			case prev != nil && entry.Seq != prev.Seq+1:
+				return fmt.Errorf("%s:%d: entry %d follows entry %d", name, line, entry.Seq, prev.Seq)
+			case prev != nil && entry.PrevHash != prev.Hash:
+				return fmt.Errorf("%s:%d: entry %d does not chain to entry %d", name, line, entry.Seq, prev.Seq)
+			}
+			prev = &entry
+			count++
+			return nil
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// readEntries calls fn for each entry of the file at path, if it exists
+func readEntries(path string, fn func(line int, entry Entry) error) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry Entry
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		// Unknown fields would not be covered by the hash
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&entry); err != nil {
+			return fmt.Errorf("%s:%d: invalid entry: %w", path, line, err)
+		}
+		if err := fn(line, entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// lastEntry returns the last entry of the file at path, or nil
+func lastEntry(path string) (*Entry, error) {
+	var last *Entry
+	err := readEntries(path, func(_ int, entry Entry) error {
+		last = &entry
+		return nil
+	})
+	return last, err
+}
+
+// backupNames returns the names of the rotated files, newest first
+func backupNames(path string, maxBackups int) []string {
+	names := make([]string, maxBackups)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s.%d", path, i+1)
+	}
+	return names
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+)
+
+func appendEntries(t *testing.T, l *Logger, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		err := l.Append(Entry{
+			Time:       time.Now().UTC(),
+			RequestID:  "req",
+			Caller:     "10.0.0.1:1234",
+			Action:     provider.ActionCreate,
+			DNSName:    "a.example.com",
+			NewTargets: []string{"1.2.3.4"},
+			Status:     provider.StatusApplied,
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+}
+
+func TestChainAcrossRotationAndRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := Open(path, 600, 2)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	appendEntries(t, l, 3)
+	l.Close()
+
+	// Reopening resumes the chain
+	l, err = Open(path, 600, 2)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	appendEntries(t, l, 3)
+	l.Close()
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("Expected the log to be rotated: %v", err)
+	}
+
+	count, err := Verify(path)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if count == 0 {
+		t.Error("Expected entries to be checked")
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	appendEntries(t, l, 3)
+	l.Close()
+
+	if count, err := Verify(path); err != nil || count != 3 {
+		t.Fatalf("Expected 3 valid entries, got %d, %v", count, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	// Altered target
+	altered := strings.Replace(string(data), "1.2.3.4", "6.6.6.6", 1)
+	if err := os.WriteFile(path, []byte(altered), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(path); err == nil || !strings.Contains(err.Error(), "altered") {
+		t.Errorf("Expected altered entry to be detected, got %v", err)
+	}
+
+	// Removed entry
+	removed := lines[0] + "\n" + lines[2] + "\n"
+	if err := os.WriteFile(path, []byte(removed), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(path); err == nil {
+		t.Error("Expected removed entry to be detected")
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ModeNone disables authentication
+	ModeNone = "none"
+	// ModeBearer requires a static bearer token
+	ModeBearer = "bearer"
+	// ModeHMAC requires HMAC-signed requests
+	ModeHMAC = "hmac"
+
+	// TimestampHeader carries the unix time at which a request was signed
+	TimestampHeader = "X-Webhook-Timestamp"
+	// SignatureHeader carries the hex-encoded HMAC-SHA256 of the request
+	SignatureHeader = "X-Webhook-Signature"
+
+	signaturePrefix = "sha256="
+)
+
+// ErrUnauthorized is returned when a request cannot be authenticated
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authenticator authenticates incoming HTTP requests
+type Authenticator interface {
+	// Authenticate returns the subject of the request, or an error
+	// wrapping ErrUnauthorized
+	Authenticate(r *http.Request) (string, error)
+
+	// Challenge is the value of the WWW-Authenticate header sent along
+	// with 401 responses
+	Challenge() string
+}
+
+type subjectKey struct{}
+
+// WithSubject returns a copy of ctx carrying the authenticated subject
+func WithSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// SubjectFromContext returns the authenticated subject, if any
+func SubjectFromContext(ctx context.Context) string {
+	subject, _ := ctx.Value(subjectKey{}).(string)
+	return subject
+}
+
+// Middleware rejects requests that auth cannot authenticate and stores the
+// subject of the others in the request context. Rejected requests are
+// answered by onError, or with a plain text 401 when it is nil.
+func Middleware(auth Authenticator, next http.Handler, onError func(http.ResponseWriter, *http.Request, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject, err := auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", auth.Challenge())
+			if onError != nil {
+				onError(w, r, err)
+				return
+			}
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithSubject(r.Context(), subject)))
+	})
+}
+
+// BearerToken authenticates requests carrying a static bearer token
+type BearerToken struct {
+	token string
+}
+
+// NewBearerToken creates a bearer token authenticator
+func NewBearerToken(token string) *BearerToken {
+	return &BearerToken{token: token}
+}
+
+// Authenticate implements Authenticator
+func (b *BearerToken) Authenticate(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return "", fmt.Errorf("%w: missing bearer token", ErrUnauthorized)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(b.token)) != 1 {
+		return "", fmt.Errorf("%w: invalid bearer token", ErrUnauthorized)
+	}
+
+	return "bearer-token", nil
+}
+
+// Challenge implements Authenticator
+func (b *BearerToken) Challenge() string {
+	return `Bearer realm="external-dns-usg-dns-api"`
+}
+
+// HMAC authenticates requests signed with a shared secret.
+//
+// The signature is the HMAC-SHA256 of the timestamp, method, request URI
+// and body, separated by newlines. Requests whose timestamp is further
+// than maxSkew from now are rejected, and so are signatures already seen
+// within that window.
+type HMAC struct {
+	secret  []byte
+	maxSkew time.Duration
+	now     func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewHMAC creates an HMAC authenticator
+func NewHMAC(secret string, maxSkew time.Duration) *HMAC {
+	return &HMAC{
+		secret:  []byte(secret),
+		maxSkew: maxSkew,
+		now:     time.Now,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// Sign computes the signature header value for the given request parts
+func Sign(secret []byte, timestamp, method, requestURI string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", timestamp, method, requestURI)
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticate implements Authenticator
+func (h *HMAC) Authenticate(r *http.Request) (string, error) {
+	timestamp := r.Header.Get(TimestampHeader)
+	signature := r.Header.Get(SignatureHeader)
+	if timestamp == "" || signature == "" {
+		return "", fmt.Errorf("%w: missing signature", ErrUnauthorized)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid timestamp", ErrUnauthorized)
+	}
+
+	now := h.now()
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-h.maxSkew)) || signedAt.After(now.Add(h.maxSkew)) {
+		return "", fmt.Errorf("%w: timestamp outside of the accepted window", ErrUnauthorized)
+	}
+
+	// Read the body and put it back for the next handler
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return "", fmt.Errorf("%w: failed to read body: %w", ErrUnauthorized, err)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := Sign(h.secret, timestamp, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", fmt.Errorf("%w: invalid signature", ErrUnauthorized)
+	}
+
+	if !h.remember(signature, signedAt, now) {
+		return "", fmt.Errorf("%w: replayed request", ErrUnauthorized)
+	}
+
+	return "hmac", nil
+}
+
+// Challenge implements Authenticator
+func (h *HMAC) Challenge() string {
+	return `HMAC realm="external-dns-usg-dns-api"`
+}
+
+// remember records a signature and reports whether it was not seen before.
+// Signatures older than the accepted window are forgotten since their
+// timestamp alone is enough to reject them.
+func (h *HMAC) remember(signature string, signedAt, now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sig, at := range h.seen {
+		if at.Before(now.Add(-h.maxSkew)) {
+			delete(h.seen, sig)
+		}
+	}
+
+	if _, ok := h.seen[signature]; ok {
+		return false
+	}
+	h.seen[signature] = signedAt
+	return true
+}
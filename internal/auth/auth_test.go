@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBearerToken(t *testing.T) {
+	auth := NewBearerToken("s3cret")
+
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"Bearer s3cret", true},
+		{"Bearer wrong", false},
+		{"s3cret", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/records", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		_, err := auth.Authenticate(req)
+		if (err == nil) != tt.ok {
+			t.Errorf("Authenticate with %q: got err=%v, expected ok=%v", tt.header, err, tt.ok)
+		}
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	auth := NewHMAC("s3cret", 5*time.Minute)
+	auth.now = func() time.Time { return now }
+
+	body := `{"create":[]}`
+	signed := func(at time.Time, secret string) *http.Request {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(body))
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, Sign([]byte(secret), timestamp, http.MethodPost, "/records", []byte(body)))
+		return req
+	}
+
+	req := signed(now, "s3cret")
+	if _, err := auth.Authenticate(req); err != nil {
+		t.Fatalf("Expected valid signature to be accepted: %v", err)
+	}
+
+	// The body must still be readable by the next handler
+	read, err := io.ReadAll(req.Body)
+	if err != nil || string(read) != body {
+		t.Errorf("Expected body to be preserved, got %q (err=%v)", read, err)
+	}
+
+	if _, err := auth.Authenticate(signed(now, "s3cret")); err == nil {
+		t.Error("Expected replayed request to be rejected")
+	}
+
+	if _, err := auth.Authenticate(signed(now.Add(-time.Second), "wrong")); err == nil {
+		t.Error("Expected wrong secret to be rejected")
+	}
+
+	if _, err := auth.Authenticate(signed(now.Add(-10*time.Minute), "s3cret")); err == nil {
+		t.Error("Expected stale timestamp to be rejected")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var subject string
+	handler := Middleware(NewBearerToken("s3cret"), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = SubjectFromContext(r.Context())
+	}), nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/records", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", rec.Code)
+	}
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("Expected WWW-Authenticate header")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/records", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+	if subject != "bearer-token" {
+		t.Errorf("Expected subject to be set, got %q", subject)
+	}
+}
@@ -1,84 +1,638 @@
 package config
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"reflect"
+	"slices"
 	"strings"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/tlsutil"
 )
 
 // Config holds the application configuration
 type Config struct {
-	// USG DNS API configuration
-	URL   string
-	Token string
+	// USG DNS API configuration. URLs are tried in order for reads.
+	URLs  []string `yaml:"usg_dns_url"`
+	Token string   `yaml:"usg_dns_token"`
+	// TokenFile is the file Token was read from, watched for rotations
+	TokenFile string `yaml:"usg_dns_token_file,omitempty"`
+
+	// USG DNS API authentication
+	ClientAuthMode     string `yaml:"usg_dns_auth_mode"`
+	ClientAuthUsername string `yaml:"usg_dns_auth_username"`
+	ClientAuthHeader   string `yaml:"usg_dns_auth_header"`
+
+	// USG DNS API TLS configuration
+	CAFile             string   `yaml:"usg_dns_ca_file"`
+	ClientCertFile     string   `yaml:"usg_dns_client_cert_file"`
+	ClientKeyFile      string   `yaml:"usg_dns_client_key_file"`
+	ServerName         string   `yaml:"usg_dns_server_name"`
+	InsecureSkipVerify bool     `yaml:"usg_dns_insecure_skip_verify"`
+	Pins               []string `yaml:"usg_dns_pins"`
+
+	// USG DNS API failover
+	WriteMode      string        `yaml:"usg_dns_write_mode"`
+	HealthInterval time.Duration `yaml:"usg_dns_health_interval"`
+
+	// USG DNS API rate limiting and circuit breaker
+	RateLimit        float64       `yaml:"usg_dns_rate_limit"`
+	RateBurst        int           `yaml:"usg_dns_rate_burst"`
+	BreakerThreshold int           `yaml:"usg_dns_breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"usg_dns_breaker_cooldown"`
+
+	// Replication between the usg-dns-api instances
+	ReplicationInterval  time.Duration `yaml:"replication_interval"`
+	ReplicationMode      string        `yaml:"replication_mode"`
+	ReplicationAuthority string        `yaml:"replication_authority"`
 
 	// Domain filter
-	DomainFilter []string
+	DomainFilter []string `yaml:"domain_filter"`
 
 	// Server configuration
-	Port       int
-	HealthPort int
+	Port        int    `yaml:"server_port"`
+	HealthPort  int    `yaml:"health_port"`
+	BindAddress string `yaml:"server_bind_address"`
+
+	// Request handling
+	MaxRequestBodyBytes int64 `yaml:"max_request_body_bytes"`
+	StrictValidation    bool  `yaml:"strict_validation"`
+
+	// Webhook API TLS configuration
+	TLSCertFile     string `yaml:"tls_cert_file"`
+	TLSKeyFile      string `yaml:"tls_key_file"`
+	TLSClientCAFile string `yaml:"tls_client_ca_file"`
+
+	// Webhook API authentication
+	AuthMode       string        `yaml:"auth_mode"`
+	AuthToken      string        `yaml:"auth_token"`
+	AuthHMACSecret string        `yaml:"auth_hmac_secret"`
+	AuthMaxSkew    time.Duration `yaml:"auth_hmac_max_skew"`
+
+	// Admin API
+	AdminPort        int    `yaml:"admin_port"`
+	AdminBindAddress string `yaml:"admin_bind_address"`
+	AdminToken       string `yaml:"admin_token"`
+
+	// Event stream and gateway monitoring
+	EventsReplaySize int           `yaml:"events_replay_size"`
+	MonitorInterval  time.Duration `yaml:"monitor_interval"`
+
+	// Web dashboard
+	DashboardPort        int    `yaml:"dashboard_port"`
+	DashboardBindAddress string `yaml:"dashboard_bind_address"`
+	DashboardTLSCertFile string `yaml:"dashboard_tls_cert_file"`
+	DashboardTLSKeyFile  string `yaml:"dashboard_tls_key_file"`
+
+	// Change notifications
+	NotifyHTTPURL  string   `yaml:"notify_http_url"`
+	NotifySlackURL string   `yaml:"notify_slack_url"`
+	NotifyFile     string   `yaml:"notify_file"`
+	NotifyRetries  int      `yaml:"notify_retries"`
+	NotifyDomains  []string `yaml:"notify_domains"`
+	NotifyEvents   []string `yaml:"notify_events"`
+
+	// Audit log
+	AuditLogFile       string `yaml:"audit_log_file"`
+	AuditLogMaxBytes   int64  `yaml:"audit_log_max_bytes"`
+	AuditLogMaxBackups int    `yaml:"audit_log_max_backups"`
+
+	// Pre-change snapshots
+	SnapshotDir    string        `yaml:"snapshot_dir"`
+	SnapshotKeep   int           `yaml:"snapshot_keep"`
+	SnapshotMaxAge time.Duration `yaml:"snapshot_max_age"`
 
 	// Options
-	DryRun bool
+	DryRun       bool          `yaml:"dry_run"`
+	CacheTTL     time.Duration `yaml:"cache_ttl"`
+	MaxDeletions int           `yaml:"max_deletions"`
+
+	// Tracing configuration
+	TracingExporter    string  `yaml:"tracing_exporter"`
+	TracingEndpoint    string  `yaml:"tracing_otlp_endpoint"`
+	TracingInsecure    bool    `yaml:"tracing_otlp_insecure"`
+	TracingSampleRatio float64 `yaml:"tracing_sample_ratio"`
+	TracingServiceName string  `yaml:"tracing_service_name"`
+
+	// File is the config file the configuration was loaded from, if any
+	File string `yaml:"-"`
 }
 
-// LoadConfig loads configuration from environment variables
+// ErrVersion is returned by Load when the version is requested with
+// --version
+var ErrVersion = errors.New("version requested")
+
+// redactedValue replaces secrets when the configuration is printed
+const redactedValue = "REDACTED"
+
+// LoadConfig loads configuration from environment variables and the config
+// file named by CONFIG_FILE
 func LoadConfig() (*Config, error) {
+	return Load(nil)
+}
+
+// Load loads configuration from the command-line arguments, environment
+// variables and a YAML or TOML config file, in this order of precedence,
+// on top of the defaults. The config file is given with --config or
+// CONFIG_FILE. flag.ErrHelp is returned when --help is given and ErrVersion
+// when --version is. All invalid settings are reported together.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("external-dns-usg-dns-api", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+
+	configFile := fs.String("config", "", "")
+	version := fs.Bool("version", false, "")
+
+	flags := make(map[string]string)
+	for _, s := range settings {
+		fs.Var(&flagValue{name: s.name, isBool: s.bool, values: flags}, flagName(s.name), "")
+		if s.file {
+			fs.Var(&flagValue{name: s.name + "_FILE", values: flags}, flagName(s.name+"_FILE"), "")
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *version {
+		return nil, ErrVersion
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+
+	src := &source{flags: flags, env: os.Getenv}
+
+	if *configFile == "" {
+		*configFile = os.Getenv("CONFIG_FILE")
+	}
+
+	// Invalid keys of the config file are reported along with the other
+	// invalid settings
+	var fileErr error
+	if *configFile != "" {
+		src.file, fileErr = readFile(*configFile)
+		if src.file == nil {
+			return nil, fileErr
+		}
+	}
+
+	config, err := load(src, fileErr)
+	if err != nil {
+		return nil, err
+	}
+	config.File = *configFile
+
+	return config, nil
+}
+
+// load resolves and validates every setting from src, on top of the errors
+// already found
+func load(src *source, errs ...error) (*Config, error) {
 	config := &Config{
-		URL:        os.Getenv("USG_DNS_URL"),
-		Token:      os.Getenv("USG_DNS_TOKEN"),
 		Port:       8888, // Default port as per external-dns spec
 		HealthPort: 8080, // Default health port
 		DryRun:     false,
+
+		MaxRequestBodyBytes: 1 << 20,
+
+		EventsReplaySize: 100,
+
+		DashboardBindAddress: "127.0.0.1",
+
+		NotifyRetries: 3,
+
+		AuditLogMaxBytes:   10 << 20,
+		AuditLogMaxBackups: 5,
+
+		SnapshotKeep:   50,
+		SnapshotMaxAge: 30 * 24 * time.Hour,
+
+		ClientAuthMode: "raw",
+
+		WriteMode:      "primary",
+		HealthInterval: 30 * time.Second,
+
+		ReplicationMode:      "one-way",
+		ReplicationAuthority: "primary",
+
+		RateBurst:        5,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+
+		AuthMode:    "none",
+		AuthMaxSkew: 5 * time.Minute,
+
+		TracingExporter:    "none",
+		TracingSampleRatio: 1.0,
+		TracingServiceName: "external-dns-usg-dns-api",
+	}
+
+	l := &loader{src: src}
+	for _, err := range errs {
+		if err != nil {
+			l.errs = append(l.errs, err)
+		}
 	}
 
 	// Validate required fields
-	if config.URL == "" {
-		return nil, fmt.Errorf("USG_DNS_URL environment variable is required")
+	if !l.list("USG_DNS_URL", &config.URLs) || len(config.URLs) == 0 {
+		l.fail("USG_DNS_URL is required")
+	}
+	for _, u := range config.URLs {
+		if err := validateURL("USG_DNS_URL", u, false); err != nil {
+			l.errs = append(l.errs, err)
+		}
 	}
 
+	l.secretFile("USG_DNS_TOKEN", &config.Token, &config.TokenFile)
 	if config.Token == "" {
-		return nil, fmt.Errorf("USG_DNS_TOKEN environment variable is required")
+		l.fail("USG_DNS_TOKEN or USG_DNS_TOKEN_FILE is required")
+	}
+
+	config.loadClientAuth(l)
+	config.loadClientTLS(l)
+	config.loadClientLimits(l)
+	config.loadFailover(l)
+	config.loadReplication(l)
+
+	l.domains("DOMAIN_FILTER", &config.DomainFilter)
+	l.bool("DRY_RUN", &config.DryRun)
+
+	if l.duration("CACHE_TTL", &config.CacheTTL) && config.CacheTTL < 0 {
+		l.fail("invalid CACHE_TTL: must not be negative")
+	}
+
+	if l.int("MAX_DELETIONS", &config.MaxDeletions) && config.MaxDeletions < 0 {
+		l.fail("invalid MAX_DELETIONS: must not be negative")
+	}
+
+	// Parse server options
+	l.int("SERVER_PORT", &config.Port)
+	l.int("HEALTH_PORT", &config.HealthPort)
+	l.string("SERVER_BIND_ADDRESS", &config.BindAddress)
+
+	// Parse request handling options
+	if l.int64("MAX_REQUEST_BODY_BYTES", &config.MaxRequestBodyBytes) && config.MaxRequestBodyBytes <= 0 {
+		l.fail("invalid MAX_REQUEST_BODY_BYTES: must be positive")
+	}
+	l.bool("STRICT_VALIDATION", &config.StrictValidation)
+
+	// Validate TLS options
+	l.string("TLS_CERT_FILE", &config.TLSCertFile)
+	l.string("TLS_KEY_FILE", &config.TLSKeyFile)
+	l.string("TLS_CLIENT_CA_FILE", &config.TLSClientCAFile)
+
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		l.fail("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
 	}
 
-	// Parse domain filter
-	domainFilterStr := os.Getenv("DOMAIN_FILTER")
-	if domainFilterStr != "" {
-		config.DomainFilter = strings.Split(domainFilterStr, ",")
-		// Trim spaces from each filter
-		for i := range config.DomainFilter {
-			config.DomainFilter[i] = strings.TrimSpace(config.DomainFilter[i])
+	if config.TLSClientCAFile != "" && config.TLSCertFile == "" {
+		l.fail("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+
+	config.loadAuth(l)
+
+	// Parse admin API options
+	l.int("ADMIN_PORT", &config.AdminPort)
+	l.string("ADMIN_BIND_ADDRESS", &config.AdminBindAddress)
+	l.secret("ADMIN_TOKEN", &config.AdminToken)
+
+	if config.AdminPort > 0 && config.AdminToken == "" {
+		l.fail("ADMIN_TOKEN or ADMIN_TOKEN_FILE is required when ADMIN_PORT is set")
+	}
+
+	// Parse event stream options
+	if l.int("EVENTS_REPLAY_SIZE", &config.EventsReplaySize) && config.EventsReplaySize < 0 {
+		l.fail("invalid EVENTS_REPLAY_SIZE: must not be negative")
+	}
+
+	if l.duration("MONITOR_INTERVAL", &config.MonitorInterval) && config.MonitorInterval < 0 {
+		l.fail("invalid MONITOR_INTERVAL: must not be negative")
+	}
+
+	// Parse dashboard options
+	l.int("DASHBOARD_PORT", &config.DashboardPort)
+	l.string("DASHBOARD_BIND_ADDRESS", &config.DashboardBindAddress)
+	l.string("DASHBOARD_TLS_CERT_FILE", &config.DashboardTLSCertFile)
+	l.string("DASHBOARD_TLS_KEY_FILE", &config.DashboardTLSKeyFile)
+
+	if (config.DashboardTLSCertFile == "") != (config.DashboardTLSKeyFile == "") {
+		l.fail("DASHBOARD_TLS_CERT_FILE and DASHBOARD_TLS_KEY_FILE must be set together")
+	}
+
+	config.loadNotify(l)
+	config.loadAudit(l)
+	config.loadSnapshots(l)
+	config.loadTracing(l)
+
+	config.validateListeners(l)
+
+	if err := errors.Join(l.errs...); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// loadClientAuth loads how the usg-dns-api client sends its token
+func (c *Config) loadClientAuth(l *loader) {
+	l.string("USG_DNS_AUTH_MODE", &c.ClientAuthMode)
+	l.string("USG_DNS_AUTH_USERNAME", &c.ClientAuthUsername)
+	l.string("USG_DNS_AUTH_HEADER", &c.ClientAuthHeader)
+
+	switch c.ClientAuthMode {
+	case "raw", "bearer", "hmac":
+	case "basic":
+		if c.ClientAuthUsername == "" {
+			l.fail("USG_DNS_AUTH_USERNAME is required when USG_DNS_AUTH_MODE is basic")
 		}
+	case "header":
+		if c.ClientAuthHeader == "" {
+			l.fail("USG_DNS_AUTH_HEADER is required when USG_DNS_AUTH_MODE is header")
+		} else if !headerName.MatchString(c.ClientAuthHeader) {
+			l.fail("invalid USG_DNS_AUTH_HEADER: %q is not a valid header name", c.ClientAuthHeader)
+		}
+	default:
+		l.fail("invalid USG_DNS_AUTH_MODE: %q (expected raw, bearer, basic, header or hmac)", c.ClientAuthMode)
 	}
+}
 
-	// Parse port
-	if portStr := os.Getenv("SERVER_PORT"); portStr != "" {
-		port, err := strconv.Atoi(portStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid SERVER_PORT: %w", err)
+// loadClientTLS loads the TLS settings of the usg-dns-api client
+func (c *Config) loadClientTLS(l *loader) {
+	l.string("USG_DNS_CA_FILE", &c.CAFile)
+	l.string("USG_DNS_CLIENT_CERT_FILE", &c.ClientCertFile)
+	l.string("USG_DNS_CLIENT_KEY_FILE", &c.ClientKeyFile)
+	l.string("USG_DNS_SERVER_NAME", &c.ServerName)
+	l.bool("USG_DNS_INSECURE_SKIP_VERIFY", &c.InsecureSkipVerify)
+	l.list("USG_DNS_PINS", &c.Pins)
+
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		l.fail("USG_DNS_CLIENT_CERT_FILE and USG_DNS_CLIENT_KEY_FILE must be set together")
+	}
+	for _, pin := range c.Pins {
+		if _, err := tlsutil.ParsePin(pin); err != nil {
+			l.fail("invalid USG_DNS_PINS: %v", err)
 		}
-		config.Port = port
 	}
 
-	// Parse health port
-	if healthPortStr := os.Getenv("HEALTH_PORT"); healthPortStr != "" {
-		healthPort, err := strconv.Atoi(healthPortStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid HEALTH_PORT: %w", err)
+	// The settings would silently be ignored over plain HTTP
+	for _, u := range c.URLs {
+		if c.ClientTLSEnabled() && strings.HasPrefix(u, "http://") {
+			l.fail("invalid USG_DNS_URL: must start with https:// when a TLS setting of usg-dns-api is set")
+			break
 		}
-		config.HealthPort = healthPort
 	}
+}
 
-	// Parse dry run
-	if dryRunStr := os.Getenv("DRY_RUN"); dryRunStr != "" {
-		dryRun, err := strconv.ParseBool(dryRunStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid DRY_RUN: %w", err)
+// loadClientLimits loads the rate limit and circuit breaker of the
+// usg-dns-api client
+func (c *Config) loadClientLimits(l *loader) {
+	if l.float("USG_DNS_RATE_LIMIT", &c.RateLimit) && c.RateLimit < 0 {
+		l.fail("invalid USG_DNS_RATE_LIMIT: must not be negative")
+	}
+	if l.int("USG_DNS_RATE_BURST", &c.RateBurst) && c.RateBurst < 1 {
+		l.fail("invalid USG_DNS_RATE_BURST: must be at least 1")
+	}
+	if l.int("USG_DNS_BREAKER_THRESHOLD", &c.BreakerThreshold) && c.BreakerThreshold < 0 {
+		l.fail("invalid USG_DNS_BREAKER_THRESHOLD: must not be negative")
+	}
+	if l.duration("USG_DNS_BREAKER_COOLDOWN", &c.BreakerCooldown) && c.BreakerCooldown <= 0 {
+		l.fail("invalid USG_DNS_BREAKER_COOLDOWN: must be positive")
+	}
+}
+
+// loadFailover loads how the usg-dns-api client uses several URLs
+func (c *Config) loadFailover(l *loader) {
+	l.string("USG_DNS_WRITE_MODE", &c.WriteMode)
+	switch c.WriteMode {
+	case "primary", "all":
+	default:
+		l.fail("invalid USG_DNS_WRITE_MODE: %q (expected primary or all)", c.WriteMode)
+	}
+
+	if l.duration("USG_DNS_HEALTH_INTERVAL", &c.HealthInterval) && c.HealthInterval <= 0 {
+		l.fail("invalid USG_DNS_HEALTH_INTERVAL: must be positive")
+	}
+}
+
+// loadReplication loads the replication settings
+func (c *Config) loadReplication(l *loader) {
+	if l.duration("REPLICATION_INTERVAL", &c.ReplicationInterval) {
+		if c.ReplicationInterval < 0 {
+			l.fail("invalid REPLICATION_INTERVAL: must not be negative")
+		} else if c.ReplicationInterval > 0 && len(c.URLs) < 2 {
+			l.fail("REPLICATION_INTERVAL requires a secondary URL in USG_DNS_URL")
 		}
-		config.DryRun = dryRun
 	}
 
-	return config, nil
+	l.string("REPLICATION_MODE", &c.ReplicationMode)
+	switch c.ReplicationMode {
+	case "one-way", "merge":
+	default:
+		l.fail("invalid REPLICATION_MODE: %q (expected one-way or merge)", c.ReplicationMode)
+	}
+
+	l.string("REPLICATION_AUTHORITY", &c.ReplicationAuthority)
+	switch c.ReplicationAuthority {
+	case "primary":
+	case "secondary", "none":
+		if c.ReplicationMode != "merge" {
+			l.fail("REPLICATION_AUTHORITY %s requires REPLICATION_MODE merge", c.ReplicationAuthority)
+		}
+	default:
+		l.fail("invalid REPLICATION_AUTHORITY: %q (expected primary, secondary or none)", c.ReplicationAuthority)
+	}
+}
+
+// loadAuth loads the webhook API authentication settings
+func (c *Config) loadAuth(l *loader) {
+	l.string("AUTH_MODE", &c.AuthMode)
+
+	switch c.AuthMode {
+	case "none":
+	case "bearer":
+		l.secret("AUTH_TOKEN", &c.AuthToken)
+		if c.AuthToken == "" {
+			l.fail("AUTH_TOKEN or AUTH_TOKEN_FILE is required when AUTH_MODE is bearer")
+		}
+	case "hmac":
+		l.secret("AUTH_HMAC_SECRET", &c.AuthHMACSecret)
+		if c.AuthHMACSecret == "" {
+			l.fail("AUTH_HMAC_SECRET or AUTH_HMAC_SECRET_FILE is required when AUTH_MODE is hmac")
+		}
+	default:
+		l.fail("invalid AUTH_MODE: %q (expected none, bearer or hmac)", c.AuthMode)
+	}
+
+	if l.duration("AUTH_HMAC_MAX_SKEW", &c.AuthMaxSkew) && c.AuthMaxSkew <= 0 {
+		l.fail("invalid AUTH_HMAC_MAX_SKEW: must be positive")
+	}
+}
+
+// loadNotify loads the change notification settings
+func (c *Config) loadNotify(l *loader) {
+	// Webhook URLs often embed a token, so they are treated as secrets
+	if l.secret("NOTIFY_HTTP_URL", &c.NotifyHTTPURL) {
+		if err := validateURL("NOTIFY_HTTP_URL", c.NotifyHTTPURL, true); err != nil {
+			l.errs = append(l.errs, err)
+		}
+	}
+	if l.secret("NOTIFY_SLACK_URL", &c.NotifySlackURL) {
+		if err := validateURL("NOTIFY_SLACK_URL", c.NotifySlackURL, true); err != nil {
+			l.errs = append(l.errs, err)
+		}
+	}
+	l.string("NOTIFY_FILE", &c.NotifyFile)
+
+	if l.int("NOTIFY_RETRIES", &c.NotifyRetries) && c.NotifyRetries < 0 {
+		l.fail("invalid NOTIFY_RETRIES: must not be negative")
+	}
+
+	l.domains("NOTIFY_DOMAINS", &c.NotifyDomains)
+	l.list("NOTIFY_EVENTS", &c.NotifyEvents)
+
+	for _, event := range c.NotifyEvents {
+		switch event {
+		case "changes.applied", "changes.failed", "changes.dry_run":
+		default:
+			l.fail("invalid NOTIFY_EVENTS: unknown event type %q", event)
+		}
+	}
+}
+
+// loadAudit loads the audit log settings
+func (c *Config) loadAudit(l *loader) {
+	l.string("AUDIT_LOG_FILE", &c.AuditLogFile)
+
+	if l.int64("AUDIT_LOG_MAX_BYTES", &c.AuditLogMaxBytes) && c.AuditLogMaxBytes < 0 {
+		l.fail("invalid AUDIT_LOG_MAX_BYTES: must not be negative")
+	}
+
+	if l.int("AUDIT_LOG_MAX_BACKUPS", &c.AuditLogMaxBackups) && c.AuditLogMaxBackups < 0 {
+		l.fail("invalid AUDIT_LOG_MAX_BACKUPS: must not be negative")
+	}
+}
+
+// loadSnapshots loads the snapshot retention settings
+func (c *Config) loadSnapshots(l *loader) {
+	l.string("SNAPSHOT_DIR", &c.SnapshotDir)
+
+	if l.int("SNAPSHOT_KEEP", &c.SnapshotKeep) && c.SnapshotKeep < 0 {
+		l.fail("invalid SNAPSHOT_KEEP: must not be negative")
+	}
+
+	if l.duration("SNAPSHOT_MAX_AGE", &c.SnapshotMaxAge) && c.SnapshotMaxAge < 0 {
+		l.fail("invalid SNAPSHOT_MAX_AGE: must not be negative")
+	}
+}
+
+// loadTracing loads the tracing settings
+func (c *Config) loadTracing(l *loader) {
+	l.string("TRACING_EXPORTER", &c.TracingExporter)
+	switch c.TracingExporter {
+	case "none", "otlp", "stdout":
+	default:
+		l.fail("invalid TRACING_EXPORTER: %q (expected none, otlp or stdout)", c.TracingExporter)
+	}
+
+	l.string("TRACING_OTLP_ENDPOINT", &c.TracingEndpoint)
+	l.bool("TRACING_OTLP_INSECURE", &c.TracingInsecure)
+
+	if l.float("TRACING_SAMPLE_RATIO", &c.TracingSampleRatio) && (c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1) {
+		l.fail("invalid TRACING_SAMPLE_RATIO: %v is not between 0 and 1", c.TracingSampleRatio)
+	}
+
+	l.string("TRACING_SERVICE_NAME", &c.TracingServiceName)
+}
+
+// Redacted returns a copy of the configuration in which the settings
+// marked secret that are set are replaced with REDACTED
+func (c *Config) Redacted() *Config {
+	redacted := *c
+
+	v := reflect.ValueOf(&redacted).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if isSecret(settingName(v.Type().Field(i))) && field.Kind() == reflect.String && field.String() != "" {
+			field.SetString(redactedValue)
+		}
+	}
+
+	return &redacted
+}
+
+// Diff returns the names of the settings whose value differs in other
+func (c *Config) Diff(other *Config) []string {
+	var changed []string
+
+	a, b := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	for i := 0; i < a.NumField(); i++ {
+		if settingName(a.Type().Field(i)) == "" {
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, settingName(a.Type().Field(i)))
+		}
+	}
+
+	return changed
+}
+
+// With returns a copy of the configuration in which the named settings
+// have their value in other
+func (c *Config) With(other *Config, names []string) *Config {
+	merged := *c
+
+	dst, src := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(other).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if slices.Contains(names, settingName(dst.Type().Field(i))) {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+
+	return &merged
+}
+
+// settingName returns the name of the setting held by a Config field, or
+// the empty string for fields which are not settings
+func settingName(field reflect.StructField) string {
+	key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if key == "-" {
+		return ""
+	}
+	return strings.ToUpper(key)
+}
+
+// NotifyEnabled reports whether at least one notification sink is configured
+func (c *Config) NotifyEnabled() bool {
+	return c.NotifyHTTPURL != "" || c.NotifySlackURL != "" || c.NotifyFile != ""
+}
+
+// splitList splits a comma-separated list, trimming spaces and dropping
+// empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ClientTLSEnabled reports whether a TLS setting of the usg-dns-api client
+// is set
+func (c *Config) ClientTLSEnabled() bool {
+	return c.CAFile != "" || c.ClientCertFile != "" || c.ServerName != "" || c.InsecureSkipVerify || len(c.Pins) > 0
+}
+
+// TLSEnabled reports whether the webhook API is served over TLS
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != ""
 }
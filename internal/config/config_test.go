@@ -0,0 +1,365 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a file in a temporary directory and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+usg_dns_url: http://file
+usg_dns_token: file-token
+domain_filter: [a.lan, b.lan]
+server_port: 9000
+health_port: 9001
+cache_ttl: 30s
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("SERVER_PORT", "7000")
+	t.Setenv("HEALTH_PORT", "7001")
+
+	cfg, err := Load([]string{"--health-port", "6001", "--dry-run"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if !slices.Equal(cfg.URLs, []string{"http://file"}) || cfg.Token != "file-token" {
+		t.Errorf("Expected settings from the config file, got %q and %q", cfg.URLs, cfg.Token)
+	}
+	if !slices.Equal(cfg.DomainFilter, []string{"a.lan", "b.lan"}) {
+		t.Errorf("Expected domain filter from the config file, got %v", cfg.DomainFilter)
+	}
+	if cfg.Port != 7000 {
+		t.Errorf("Expected environment to override the config file, got port %d", cfg.Port)
+	}
+	if cfg.HealthPort != 6001 {
+		t.Errorf("Expected flags to override the environment, got health port %d", cfg.HealthPort)
+	}
+	if !cfg.DryRun || cfg.CacheTTL != 30*time.Second {
+		t.Errorf("Unexpected dry run %v or cache TTL %s", cfg.DryRun, cfg.CacheTTL)
+	}
+	if cfg.AuditLogMaxBackups != 5 {
+		t.Errorf("Expected defaults for unset settings, got %d", cfg.AuditLogMaxBackups)
+	}
+}
+
+func TestLoadTOML(t *testing.T) {
+	path := writeFile(t, "config.toml", `
+usg_dns_url = "http://toml"
+usg_dns_token = "token"
+notify_events = ["changes.applied", "changes.failed"]
+tracing_sample_ratio = 0.5
+`)
+
+	cfg, err := Load([]string{"--config", path})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !slices.Equal(cfg.URLs, []string{"http://toml"}) || cfg.TracingSampleRatio != 0.5 || len(cfg.NotifyEvents) != 2 {
+		t.Errorf("Unexpected configuration: %+v", cfg)
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	secret := writeFile(t, "token", "from-file\n")
+	path := writeFile(t, "config.yaml", "usg_dns_url: http://file\nusg_dns_token: token\nauth_mode: bearer\nauth_token: file-value\n")
+
+	// A file given in a higher layer wins over a value of the config file
+	cfg, err := Load([]string{"--config", path, "--auth-token-file", secret})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.AuthToken != "from-file" {
+		t.Errorf("Expected token read from file, got %q", cfg.AuthToken)
+	}
+
+	// Both in the same layer are ambiguous
+	_, err = Load([]string{"--config", path, "--auth-token", "a", "--auth-token-file", secret})
+	if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+		t.Errorf("Expected mutually exclusive error, got %v", err)
+	}
+}
+
+func TestLoadAggregatesErrors(t *testing.T) {
+	path := writeFile(t, "config.yaml", "unknown_key: 1\nserver_port: {nested: 1}\n")
+
+	_, err := Load([]string{
+		"--config", path,
+		"--cache-ttl", "-1s",
+		"--auth-mode", "magic",
+		"--tracing-sample-ratio", "2",
+	})
+	if err == nil {
+		t.Fatal("Expected Load to fail")
+	}
+
+	for _, want := range []string{
+		`unknown setting "unknown_key"`,
+		"invalid server_port",
+		"USG_DNS_URL is required",
+		"USG_DNS_TOKEN or USG_DNS_TOKEN_FILE is required",
+		"invalid CACHE_TTL",
+		"invalid AUTH_MODE",
+		"invalid TRACING_SAMPLE_RATIO",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := &Config{URLs: []string{"http://gateway"}, Token: "token", NotifySlackURL: ""}
+
+	redacted := cfg.Redacted()
+	if redacted.Token != redactedValue || redacted.URLs[0] != "http://gateway" {
+		t.Errorf("Unexpected redacted configuration: %+v", redacted)
+	}
+	if redacted.NotifySlackURL != "" {
+		t.Errorf("Expected unset secrets to stay empty, got %q", redacted.NotifySlackURL)
+	}
+	if cfg.Token != "token" {
+		t.Errorf("Expected the original configuration to be left alone")
+	}
+
+	// Every secret setting is redacted, through the field holding it
+	secrets := &Config{}
+	v := reflect.ValueOf(secrets).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if isSecret(settingName(v.Type().Field(i))) {
+			v.Field(i).SetString("secret")
+		}
+	}
+	for _, s := range settings {
+		if !s.secret {
+			continue
+		}
+		value, ok := fieldOf(secrets.Redacted(), s.name)
+		if !ok || value != redactedValue {
+			t.Errorf("Expected %s to be redacted, got %q", s.name, value)
+		}
+	}
+}
+
+// fieldOf returns the value of the string field holding the named setting
+func fieldOf(cfg *Config, name string) (string, bool) {
+	v := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if settingName(v.Type().Field(i)) == name {
+			return v.Field(i).String(), true
+		}
+	}
+	return "", false
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	tokenFile := writeFile(t, "token", "rotated\n")
+
+	cfg, err := Load([]string{"--usg-dns-url", "http://gateway", "--usg-dns-token-file", tokenFile})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Token != "rotated" || cfg.TokenFile != tokenFile {
+		t.Errorf("Expected token read from %s, got %q from %q", tokenFile, cfg.Token, cfg.TokenFile)
+	}
+}
+
+func TestLoadStrictValidation(t *testing.T) {
+	_, err := Load([]string{
+		"--usg-dns-url", "192.168.1.1:8080",
+		"--usg-dns-token", "token",
+		"--domain-filter", "example.com,,bad_domain!.lan",
+		"--server-port", "8080",
+		"--health-port", "8080",
+		"--admin-port", "70000",
+		"--admin-token", "admin",
+		"--notify-http-url", "ftp://secret-token@example.com",
+	})
+	if err == nil {
+		t.Fatal("Expected Load to fail")
+	}
+
+	for _, want := range []string{
+		"invalid USG_DNS_URL",
+		"invalid DOMAIN_FILTER: empty entry",
+		`invalid DOMAIN_FILTER: "bad_domain!.lan"`,
+		"invalid HEALTH_PORT: port 8080 is already used by SERVER_PORT",
+		"invalid ADMIN_PORT: 70000 is not between 1 and 65535",
+		"invalid NOTIFY_HTTP_URL",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("Expected secrets to be left out of errors, got:\n%v", err)
+	}
+}
+
+func TestLoadListenersOnDistinctAddresses(t *testing.T) {
+	_, err := Load([]string{
+		"--usg-dns-url", "https://gateway.lan",
+		"--usg-dns-token", "token",
+		"--domain-filter", ".example.com,_acme.test.lan.",
+		"--server-bind-address", "127.0.0.1",
+		"--admin-port", "8888",
+		"--admin-bind-address", "10.0.0.1",
+		"--admin-token", "admin",
+	})
+	if err != nil {
+		t.Errorf("Expected the same port on distinct addresses to be accepted, got %v", err)
+	}
+}
+
+func TestLoadHealthPortOnAllInterfaces(t *testing.T) {
+	_, err := Load([]string{
+		"--usg-dns-url", "https://gateway.lan",
+		"--usg-dns-token", "token",
+		"--server-bind-address", "10.0.0.1",
+		"--health-port", "9090",
+		"--admin-port", "9090",
+		"--admin-bind-address", "127.0.0.1",
+		"--admin-token", "admin",
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid ADMIN_PORT: port 9090 is already used by HEALTH_PORT") {
+		t.Errorf("Expected the health endpoint to collide on all interfaces, got %v", err)
+	}
+}
+
+func TestLoadClientTLS(t *testing.T) {
+	_, err := Load([]string{
+		"--usg-dns-url", "http://gateway.lan",
+		"--usg-dns-token", "token",
+		"--usg-dns-client-cert-file", "client.crt",
+		"--usg-dns-pins", "sha256/AAAA",
+	})
+	if err == nil {
+		t.Fatal("Expected Load to fail")
+	}
+
+	for _, want := range []string{
+		"USG_DNS_CLIENT_CERT_FILE and USG_DNS_CLIENT_KEY_FILE must be set together",
+		"invalid USG_DNS_PINS",
+		"invalid USG_DNS_URL: must start with https://",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadClientAuth(t *testing.T) {
+	base := []string{"--usg-dns-url", "https://gateway.lan", "--usg-dns-token", "token"}
+
+	cfg, err := Load(base)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ClientAuthMode != "raw" {
+		t.Errorf("Expected the raw mode by default, got %q", cfg.ClientAuthMode)
+	}
+
+	for mode, want := range map[string]string{
+		"basic":  "USG_DNS_AUTH_USERNAME is required",
+		"header": "USG_DNS_AUTH_HEADER is required",
+		"digest": "invalid USG_DNS_AUTH_MODE",
+	} {
+		_, err := Load(append(base, "--usg-dns-auth-mode", mode))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error containing %q for mode %s, got %v", want, mode, err)
+		}
+	}
+}
+
+func TestLoadFailover(t *testing.T) {
+	cfg, err := Load([]string{"--usg-dns-url", "http://gw1.lan, http://gw2.lan", "--usg-dns-token", "token"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !slices.Equal(cfg.URLs, []string{"http://gw1.lan", "http://gw2.lan"}) || cfg.WriteMode != "primary" {
+		t.Errorf("Unexpected configuration: %q, write mode %q", cfg.URLs, cfg.WriteMode)
+	}
+
+	_, err = Load([]string{
+		"--usg-dns-url", "http://gw1.lan,gw2.lan",
+		"--usg-dns-token", "token",
+		"--usg-dns-write-mode", "quorum",
+	})
+	if err == nil {
+		t.Fatal("Expected Load to fail")
+	}
+	for _, want := range []string{
+		`invalid USG_DNS_URL: "gw2.lan" must start with http://`,
+		"invalid USG_DNS_WRITE_MODE",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadReplication(t *testing.T) {
+	_, err := Load([]string{
+		"--usg-dns-url", "http://gw1.lan",
+		"--usg-dns-token", "token",
+		"--replication-interval", "1m",
+		"--replication-authority", "secondary",
+	})
+	if err == nil {
+		t.Fatal("Expected Load to fail")
+	}
+	for _, want := range []string{
+		"REPLICATION_INTERVAL requires a secondary URL",
+		"REPLICATION_AUTHORITY secondary requires REPLICATION_MODE merge",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got:\n%v", want, err)
+		}
+	}
+
+	cfg, err := Load([]string{
+		"--usg-dns-url", "http://gw1.lan,http://gw2.lan",
+		"--usg-dns-token", "token",
+		"--replication-interval", "1m",
+		"--replication-mode", "merge",
+		"--replication-authority", "none",
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.ReplicationInterval != time.Minute || cfg.ReplicationAuthority != "none" {
+		t.Errorf("Unexpected configuration: %+v", cfg)
+	}
+}
+
+func TestLoadDashboard(t *testing.T) {
+	base := []string{"--usg-dns-url", "https://gateway.lan", "--usg-dns-token", "token", "--dashboard-port", "8081"}
+
+	cfg, err := Load(base)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.DashboardBindAddress != "127.0.0.1" {
+		t.Errorf("Expected the dashboard on the loopback interface by default, got %q", cfg.DashboardBindAddress)
+	}
+
+	_, err = Load(append(base, "--dashboard-tls-cert-file", "dashboard.crt"))
+	if err == nil || !strings.Contains(err.Error(), "DASHBOARD_TLS_CERT_FILE and DASHBOARD_TLS_KEY_FILE must be set together") {
+		t.Errorf("Expected a certificate without its key to be rejected, got %v", err)
+	}
+}
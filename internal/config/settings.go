@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// setting describes a configuration setting. Its name is the environment
+// variable; the command-line flag and the config file key are derived from
+// it (USG_DNS_URL, --usg-dns-url and usg_dns_url).
+type setting struct {
+	name  string
+	usage string
+	bool  bool
+	// secret settings are redacted when the configuration is printed
+	secret bool
+	// file settings can also be read from the file named by NAME_FILE
+	file bool
+	// reloadable settings are applied without a restart on reload
+	reloadable bool
+}
+
+// settings lists every setting, in the order they are documented
+var settings = []setting{
+	{name: "USG_DNS_URL", usage: "URL of usg-dns-api, or comma-separated URLs tried in order for failover (required)"},
+	{name: "USG_DNS_TOKEN", usage: "token of usg-dns-api (required)", secret: true, file: true, reloadable: true},
+	{name: "USG_DNS_AUTH_MODE", usage: "how the token is sent to usg-dns-api: raw, bearer, basic, header or hmac"},
+	{name: "USG_DNS_AUTH_USERNAME", usage: "basic auth username, the token being the password"},
+	{name: "USG_DNS_AUTH_HEADER", usage: "header carrying the token in header mode"},
+	{name: "USG_DNS_CA_FILE", usage: "CA bundle trusted for the certificate of usg-dns-api instead of the system CAs"},
+	{name: "USG_DNS_CLIENT_CERT_FILE", usage: "client certificate presented to usg-dns-api"},
+	{name: "USG_DNS_CLIENT_KEY_FILE", usage: "private key of the client certificate"},
+	{name: "USG_DNS_SERVER_NAME", usage: "name the certificate of usg-dns-api is verified against (default: host of each USG_DNS_URL)"},
+	{name: "USG_DNS_INSECURE_SKIP_VERIFY", usage: "do not verify the certificate of usg-dns-api (pins are still checked)", bool: true},
+	{name: "USG_DNS_PINS", usage: "comma-separated sha256/BASE64 SPKI pins, one of which the usg-dns-api chain must match"},
+	{name: "USG_DNS_WRITE_MODE", usage: "how writes are sent with several USG_DNS_URL: primary or all"},
+	{name: "USG_DNS_HEALTH_INTERVAL", usage: "interval of the health checks of the USG_DNS_URL instances"},
+	{name: "USG_DNS_RATE_LIMIT", usage: "maximum requests per second sent to usg-dns-api (0 disables the limit)"},
+	{name: "USG_DNS_RATE_BURST", usage: "requests sent to usg-dns-api at once before the rate limit applies"},
+	{name: "USG_DNS_BREAKER_THRESHOLD", usage: "consecutive usg-dns-api failures opening the circuit breaker (0 disables it)"},
+	{name: "USG_DNS_BREAKER_COOLDOWN", usage: "how long the circuit breaker stays open before a probe request"},
+	{name: "REPLICATION_INTERVAL", usage: "how often the records of the first USG_DNS_URL are replicated to the others (0 disables replication)"},
+	{name: "REPLICATION_MODE", usage: "replication mode: one-way or merge"},
+	{name: "REPLICATION_AUTHORITY", usage: "instance whose targets win a conflict in merge mode: primary, secondary or none"},
+	{name: "DOMAIN_FILTER", usage: "comma-separated domains to manage", reloadable: true},
+	{name: "DRY_RUN", usage: "only log the changes", bool: true, reloadable: true},
+	{name: "CACHE_TTL", usage: "how long the inventory of usg-dns-api is served from memory"},
+	{name: "MAX_DELETIONS", usage: "refuse batches deleting more records (0 disables the limit)", reloadable: true},
+
+	{name: "SERVER_PORT", usage: "webhook API port"},
+	{name: "SERVER_BIND_ADDRESS", usage: "address the webhook API listens on (the health endpoint listens on all interfaces)"},
+	{name: "HEALTH_PORT", usage: "health endpoint port"},
+	{name: "MAX_REQUEST_BODY_BYTES", usage: "maximum size of request bodies", reloadable: true},
+	{name: "STRICT_VALIDATION", usage: "reject unknown JSON fields", bool: true, reloadable: true},
+
+	{name: "TLS_CERT_FILE", usage: "certificate of the webhook API"},
+	{name: "TLS_KEY_FILE", usage: "private key of the webhook API"},
+	{name: "TLS_CLIENT_CA_FILE", usage: "CA bundle required to verify client certificates"},
+
+	{name: "AUTH_MODE", usage: "webhook API authentication: none, bearer or hmac"},
+	{name: "AUTH_TOKEN", usage: "bearer token of the webhook API", secret: true, file: true},
+	{name: "AUTH_HMAC_SECRET", usage: "HMAC secret of the webhook API", secret: true, file: true},
+	{name: "AUTH_HMAC_MAX_SKEW", usage: "maximum age of HMAC signed requests"},
+
+	{name: "ADMIN_PORT", usage: "admin API port (0 disables it)"},
+	{name: "ADMIN_BIND_ADDRESS", usage: "address the admin API listens on"},
+	{name: "ADMIN_TOKEN", usage: "bearer token of the admin API", secret: true, file: true},
+	{name: "EVENTS_REPLAY_SIZE", usage: "number of events kept for resuming clients"},
+	{name: "MONITOR_INTERVAL", usage: "how often the gateway is polled for drift (0 disables it)"},
+
+	{name: "DASHBOARD_PORT", usage: "web dashboard port (0 disables it)"},
+	{name: "DASHBOARD_BIND_ADDRESS", usage: "address the web dashboard listens on"},
+	{name: "DASHBOARD_TLS_CERT_FILE", usage: "certificate of the web dashboard"},
+	{name: "DASHBOARD_TLS_KEY_FILE", usage: "private key of the web dashboard"},
+
+	{name: "NOTIFY_HTTP_URL", usage: "URL receiving change events", secret: true, file: true},
+	{name: "NOTIFY_SLACK_URL", usage: "Slack-compatible incoming webhook URL", secret: true, file: true},
+	{name: "NOTIFY_FILE", usage: "file to which change events are appended"},
+	{name: "NOTIFY_RETRIES", usage: "retries of failed HTTP notifications"},
+	{name: "NOTIFY_DOMAINS", usage: "only notify about these comma-separated domains"},
+	{name: "NOTIFY_EVENTS", usage: "only send these comma-separated event types"},
+
+	{name: "AUDIT_LOG_FILE", usage: "file to which every change is appended"},
+	{name: "AUDIT_LOG_MAX_BYTES", usage: "size after which the audit log is rotated"},
+	{name: "AUDIT_LOG_MAX_BACKUPS", usage: "number of rotated audit log files kept"},
+
+	{name: "SNAPSHOT_DIR", usage: "directory of the snapshots taken before changes"},
+	{name: "SNAPSHOT_KEEP", usage: "number of snapshots kept"},
+	{name: "SNAPSHOT_MAX_AGE", usage: "age after which snapshots are removed"},
+
+	{name: "TRACING_EXPORTER", usage: "trace exporter: none, otlp or stdout"},
+	{name: "TRACING_OTLP_ENDPOINT", usage: "OTLP/HTTP collector endpoint"},
+	{name: "TRACING_OTLP_INSECURE", usage: "send traces over plain HTTP", bool: true},
+	{name: "TRACING_SAMPLE_RATIO", usage: "fraction of traces sampled"},
+	{name: "TRACING_SERVICE_NAME", usage: "service name reported in traces"},
+}
+
+// names returns the names of every known setting, including the NAME_FILE
+// variants of file settings
+func names() []string {
+	var all []string
+	for _, s := range settings {
+		all = append(all, s.name)
+		if s.file {
+			all = append(all, s.name+"_FILE")
+		}
+	}
+	return all
+}
+
+// Reloadable reports whether a setting is applied without a restart when
+// the configuration is reloaded
+func Reloadable(name string) bool {
+	for _, s := range settings {
+		if s.name == name {
+			return s.reloadable
+		}
+	}
+	return false
+}
+
+// isSecret reports whether a setting is redacted when the configuration is
+// printed
+func isSecret(name string) bool {
+	for _, s := range settings {
+		if s.name == name {
+			return s.secret
+		}
+	}
+	return false
+}
+
+// flagName returns the command-line flag of a setting
+func flagName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+// fileKey returns the config file key of a setting
+func fileKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// PrintUsage writes the description of every flag to w
+func PrintUsage(w io.Writer) {
+	fmt.Fprintf(w, "  --%-28s %s\n", "config FILE", "YAML or TOML config file (also CONFIG_FILE)")
+	fmt.Fprintf(w, "  --%-28s %s\n", "version", "print the version and exit")
+	fmt.Fprintf(w, "  --%-28s %s\n", "help", "print this help and exit")
+	for _, s := range settings {
+		fmt.Fprintf(w, "  --%-28s %s\n", flagName(s.name), s.usage)
+		if s.file {
+			fmt.Fprintf(w, "  --%-28s %s\n", flagName(s.name+"_FILE"), "file containing "+flagName(s.name))
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Layers of a source, from the highest precedence
+const (
+	layerFlags = iota
+	layerEnv
+	layerFile
+	layerNone
+)
+
+// source resolves settings from the command-line flags, the environment
+// and the config file, in this order of precedence. Empty values are
+// treated as unset.
+type source struct {
+	flags map[string]string
+	env   func(string) string
+	file  map[string]string
+}
+
+// lookup returns the value of a setting and the layer it comes from
+func (s *source) lookup(name string) (string, int) {
+	if value := s.flags[name]; value != "" {
+		return value, layerFlags
+	}
+	if value := s.env(name); value != "" {
+		return value, layerEnv
+	}
+	if value := s.file[name]; value != "" {
+		return value, layerFile
+	}
+	return "", layerNone
+}
+
+// get returns the value of a setting, or the empty string
+func (s *source) get(name string) string {
+	value, _ := s.lookup(name)
+	return value
+}
+
+// secret returns the value of a setting, or the content of the file named
+// by NAME_FILE along with its path. When both are set, the one from the
+// layer of highest precedence wins; they are mutually exclusive within a
+// layer.
+func (s *source) secret(name string) (string, string, error) {
+	value, valueLayer := s.lookup(name)
+	path, pathLayer := s.lookup(name + "_FILE")
+
+	switch {
+	case pathLayer == layerNone || valueLayer < pathLayer:
+		return value, "", nil
+	case valueLayer == pathLayer:
+		return "", "", fmt.Errorf("%s and %s_FILE are mutually exclusive", name, name)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+
+	return strings.TrimSpace(string(data)), path, nil
+}
+
+// readFile reads a YAML or TOML config file, depending on its extension,
+// into setting values. Keys are the lower case names of the settings.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := make(map[string]any)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid config file %s: expected a .yaml, .yml or .toml extension", path)
+	}
+
+	known := make(map[string]bool)
+	for _, name := range names() {
+		known[fileKey(name)] = true
+	}
+
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	values := make(map[string]string, len(raw))
+	for _, key := range keys {
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("unknown setting %q in %s", key, path))
+			continue
+		}
+		value, err := fileValue(raw[key])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s in %s: %w", key, path, err))
+			continue
+		}
+		values[strings.ToUpper(key)] = value
+	}
+
+	return values, errors.Join(errs...)
+}
+
+// fileValue converts a value of a config file to its environment variable
+// form: lists are joined with commas
+func fileValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", value)
+	}
+}
+
+// flagValue records the value of a setting given on the command line
+type flagValue struct {
+	name   string
+	isBool bool
+	values map[string]string
+}
+
+func (f *flagValue) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return f.values[f.name]
+}
+
+func (f *flagValue) Set(value string) error {
+	f.values[f.name] = value
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.isBool
+}
+
+// loader parses settings from a source into typed values, collecting the
+// errors so that they are all reported at once. Each parser leaves dst
+// untouched when the setting is unset and reports whether it was set.
+type loader struct {
+	src  *source
+	errs []error
+}
+
+// fail records an error
+func (l *loader) fail(format string, args ...any) {
+	l.errs = append(l.errs, fmt.Errorf(format, args...))
+}
+
+func (l *loader) string(name string, dst *string) bool {
+	value := l.src.get(name)
+	if value == "" {
+		return false
+	}
+	*dst = value
+	return true
+}
+
+func (l *loader) secret(name string, dst *string) bool {
+	return l.secretFile(name, dst, nil)
+}
+
+// secretFile also sets path to the file the secret was read from, if any
+func (l *loader) secretFile(name string, dst, path *string) bool {
+	value, file, err := l.src.secret(name)
+	if err != nil {
+		l.errs = append(l.errs, err)
+		return false
+	}
+	if value == "" {
+		return false
+	}
+	*dst = value
+	if path != nil {
+		*path = file
+	}
+	return true
+}
+
+func (l *loader) list(name string, dst *[]string) bool {
+	value := l.src.get(name)
+	if value == "" {
+		return false
+	}
+	*dst = splitList(value)
+	return true
+}
+
+// domains parses a comma-separated list of domain filters, rejecting empty
+// entries and invalid names
+func (l *loader) domains(name string, dst *[]string) bool {
+	value := l.src.get(name)
+	if value == "" {
+		return false
+	}
+
+	var domains []string
+	valid := true
+	for _, domain := range strings.Split(value, ",") {
+		domain = strings.TrimSpace(domain)
+		if err := validateDomain(domain); err != nil {
+			l.fail("invalid %s: %w", name, err)
+			valid = false
+			continue
+		}
+		domains = append(domains, domain)
+	}
+	if valid {
+		*dst = domains
+	}
+	return valid
+}
+
+func (l *loader) int(name string, dst *int) bool {
+	return parse(l, name, dst, strconv.Atoi)
+}
+
+func (l *loader) int64(name string, dst *int64) bool {
+	return parse(l, name, dst, func(s string) (int64, error) {
+		return strconv.ParseInt(s, 10, 64)
+	})
+}
+
+func (l *loader) float(name string, dst *float64) bool {
+	return parse(l, name, dst, func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	})
+}
+
+func (l *loader) bool(name string, dst *bool) bool {
+	return parse(l, name, dst, strconv.ParseBool)
+}
+
+func (l *loader) duration(name string, dst *time.Duration) bool {
+	return parse(l, name, dst, time.ParseDuration)
+}
+
+// parse sets dst to the parsed value of a setting
+func parse[T any](l *loader, name string, dst *T, parseFunc func(string) (T, error)) bool {
+	value := l.src.get(name)
+	if value == "" {
+		return false
+	}
+	parsed, err := parseFunc(value)
+	if err != nil {
+		l.fail("invalid %s: %w", name, err)
+		return false
+	}
+	*dst = parsed
+	return true
+}
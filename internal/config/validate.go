@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// domainLabel matches a label of a domain filter. Underscores are accepted
+// for names such as _acme-challenge.
+var domainLabel = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]{0,61}[A-Za-z0-9_])?$`)
+
+// headerName matches the name of an HTTP header
+var headerName = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// validateURL checks that value is an absolute http or https URL. The value
+// of secret settings is not repeated in the error.
+func validateURL(name, value string, secret bool) error {
+	quoted := fmt.Sprintf("%q", value)
+	if secret {
+		quoted = "value"
+	}
+
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid %s: %s must start with http:// or https://", name, quoted)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid %s: %s has no host", name, quoted)
+	}
+	return nil
+}
+
+// validateDomain checks the syntax of a domain of a filter. A leading dot
+// and a trailing dot are accepted.
+func validateDomain(domain string) error {
+	name := strings.TrimSuffix(strings.TrimPrefix(domain, "."), ".")
+	if name == "" {
+		return fmt.Errorf("empty entry, check for extra commas")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("%q is longer than 253 characters", domain)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !domainLabel.MatchString(label) {
+			return fmt.Errorf("%q is not a valid domain name (label %q)", domain, label)
+		}
+	}
+	return nil
+}
+
+// listener is a port the process listens on
+type listener struct {
+	name    string
+	address string
+	port    int
+}
+
+// validateListeners checks the port ranges and that no two listeners use
+// the same port on overlapping addresses
+func (c *Config) validateListeners(l *loader) {
+	listeners := []listener{
+		{"SERVER_PORT", c.BindAddress, c.Port},
+		// The health endpoint ignores SERVER_BIND_ADDRESS, so that the
+		// kubelet can reach it
+		{"HEALTH_PORT", "", c.HealthPort},
+	}
+	if c.AdminPort != 0 {
+		listeners = append(listeners, listener{"ADMIN_PORT", c.AdminBindAddress, c.AdminPort})
+	}
+	if c.DashboardPort != 0 {
+		listeners = append(listeners, listener{"DASHBOARD_PORT", c.DashboardBindAddress, c.DashboardPort})
+	}
+
+	for i, a := range listeners {
+		if a.port < 1 || a.port > 65535 {
+			l.fail("invalid %s: %d is not between 1 and 65535", a.name, a.port)
+			continue
+		}
+		for _, b := range listeners[:i] {
+			// An empty address listens on all interfaces, so it collides
+			// with any other address
+			overlap := a.address == "" || b.address == "" || a.address == b.address
+			if overlap && a.port == b.port {
+				l.fail("invalid %s: port %d is already used by %s", a.name, a.port, b.name)
+			}
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package dashboard
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/version"
+)
+
+//go:embed templates/*.html
+var templates embed.FS
+
+// maxAutoRefresh bounds the auto-refresh interval accepted in the query
+const maxAutoRefresh = 3600
+
+// Dashboard serves a read-only HTML view of the provider state
+type Dashboard struct {
+	provider *provider.Provider
+	ready    func() error
+	tmpl     *template.Template
+}
+
+// page holds the data rendered by the template
+type page struct {
+	Version      string
+	GeneratedAt  time.Time
+	AutoRefresh  int
+	DryRun       bool
+	Paused       bool
+	DomainFilter []string
+	CachedAt     time.Time
+	BackendError string
+	// GatewayError is why usg-dns-api is not considered healthy, empty
+	// when it is
+	GatewayError string
+	Records      []provider.ManagedRecord
+	Batches      []provider.Batch
+}
+
+// New creates a dashboard for the given provider. The gateway is shown as
+// unhealthy while ready, if not nil, returns an error, or when the last
+// inventory fetch failed.
+func New(prov *provider.Provider, ready func() error) *Dashboard {
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{
+		"formatTime": formatTime,
+	}).ParseFS(templates, "templates/*.html"))
+
+	return &Dashboard{
+		provider: prov,
+		ready:    ready,
+		tmpl:     tmpl,
+	}
+}
+
+// formatTime renders a time.Time or a *time.Time in local time
+func formatTime(v any) string {
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v != nil {
+			t = *v
+		}
+	}
+
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// ServeHTTP renders the dashboard. The refresh query parameter forces an
+// inventory fetch from usg-dns-api, and auto sets a refresh interval in
+// seconds.
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data := page{
+		Version:      version.VersionFull(),
+		GeneratedAt:  time.Now(),
+		DryRun:       d.provider.DryRun(),
+		Paused:       d.provider.Paused(),
+		DomainFilter: d.provider.GetDomainFilter().Filters,
+		Batches:      d.provider.History(),
+	}
+
+	if auto, err := strconv.Atoi(r.URL.Query().Get("auto")); err == nil && auto > 0 && auto <= maxAutoRefresh {
+		data.AutoRefresh = auto
+	}
+
+	var err error
+	if r.URL.Query().Has("refresh") {
+		_, err = d.provider.RefreshCache(r.Context())
+	}
+	if err == nil {
+		data.Records, err = d.provider.ManagedRecords(r.Context())
+	}
+	if err != nil {
+		data.BackendError = err.Error()
+	}
+	data.CachedAt = d.provider.CachedAt()
+
+	// Records may come from the cache, which tells nothing of the gateway
+	if d.ready != nil {
+		err = d.ready()
+	}
+	if err == nil {
+		err = d.provider.FetchError()
+	}
+	if err != nil {
+		data.GatewayError = err.Error()
+	}
+
+	// Render to a buffer first so that a template error does not send a
+	// truncated page
+	var buf bytes.Buffer
+	if err := d.tmpl.ExecuteTemplate(&buf, "index.html", data); err != nil {
+		log.Printf("Failed to render dashboard: %v", err)
+		http.Error(w, "Failed to render dashboard", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,115 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// newTestDashboard returns a dashboard backed by a fake usg-dns-api, which
+// fails while the returned flag is set
+func newTestDashboard(t *testing.T, ready func() error) (*Dashboard, *atomic.Bool) {
+	t.Helper()
+
+	var failing atomic.Bool
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "gateway down", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`[{"id":"1","name":"nas.lan","target":"10.0.0.1"}]`))
+	}))
+	t.Cleanup(gateway.Close)
+
+	client := usgdns.NewClient(gateway.URL, "test-token")
+	prov := provider.NewProvider(client, nil, false, provider.WithCacheTTL(time.Hour))
+	return New(prov, ready), &failing
+}
+
+func get(d *Dashboard, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestDashboardRouting(t *testing.T) {
+	d, _ := newTestDashboard(t, nil)
+
+	if rec := get(d, "/records"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another path, got %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+
+	rec = get(d, "/")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != "default-src 'none'; style-src 'unsafe-inline'" {
+		t.Errorf("Unexpected Content-Security-Policy: %q", csp)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "nas.lan") || !strings.Contains(body, "Gateway healthy") {
+		t.Errorf("Expected the records and a healthy gateway, got %s", body)
+	}
+}
+
+func TestDashboardAutoRefresh(t *testing.T) {
+	d, _ := newTestDashboard(t, nil)
+
+	tests := []struct {
+		query   string
+		refresh bool
+	}{
+		{"auto=30", true},
+		{"auto=3600", true},
+		{"auto=3601", false},
+		{"auto=0", false},
+		{"auto=-5", false},
+		{"auto=soon", false},
+	}
+
+	for _, tt := range tests {
+		body := get(d, "/?"+tt.query).Body.String()
+		if got := strings.Contains(body, `http-equiv="refresh"`); got != tt.refresh {
+			t.Errorf("%s: expected refresh=%v, got %v", tt.query, tt.refresh, got)
+		}
+	}
+}
+
+func TestDashboardGatewayErrors(t *testing.T) {
+	var ready error
+	d, failing := newTestDashboard(t, func() error { return ready })
+
+	// Fill the cache, then take the gateway down
+	get(d, "/")
+	failing.Store(true)
+
+	// The cached records do not make the gateway healthy once a fetch failed
+	body := get(d, "/?refresh=1").Body.String()
+	if !strings.Contains(body, "Gateway unreachable") || !strings.Contains(body, "gateway down") {
+		t.Errorf("Expected the fetch error to be rendered, got %s", body)
+	}
+	body = get(d, "/").Body.String()
+	if !strings.Contains(body, "nas.lan") || !strings.Contains(body, "Gateway unreachable") {
+		t.Errorf("Expected the cached records with an unreachable gateway, got %s", body)
+	}
+
+	// The readiness check fails the gateway even when its last fetch succeeded
+	failing.Store(false)
+	get(d, "/?refresh=1")
+	ready = usgdns.ErrCircuitOpen
+	body = get(d, "/").Body.String()
+	if !strings.Contains(body, "Gateway unreachable") || !strings.Contains(body, usgdns.ErrCircuitOpen.Error()) {
+		t.Errorf("Expected the readiness error to be rendered, got %s", body)
+	}
+}
@@ -0,0 +1,95 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// Compute returns the changes turning current into desired. Endpoints are
+// matched by name and differ when their targets do, regardless of order.
+// Changes are sorted by name so that plans are stable.
+func Compute(current, desired []*webhook.Endpoint) *webhook.Changes {
+	currentByName := byName(current)
+	desiredByName := byName(desired)
+
+	changes := &webhook.Changes{}
+	for _, name := range sortedNames(desiredByName) {
+		want := desiredByName[name]
+		have, ok := currentByName[name]
+		switch {
+		case !ok:
+			changes.Create = append(changes.Create, want)
+		case !sameTargets(have.Targets, want.Targets):
+			changes.UpdateOld = append(changes.UpdateOld, have)
+			changes.UpdateNew = append(changes.UpdateNew, want)
+		}
+	}
+	for _, name := range sortedNames(currentByName) {
+		if _, ok := desiredByName[name]; !ok {
+			changes.Delete = append(changes.Delete, currentByName[name])
+		}
+	}
+
+	return changes
+}
+
+// Filter returns the endpoints whose name satisfies keep
+func Filter(endpoints []*webhook.Endpoint, keep func(name string) bool) []*webhook.Endpoint {
+	var kept []*webhook.Endpoint
+	for _, endpoint := range endpoints {
+		if keep(endpoint.DNSName) {
+			kept = append(kept, endpoint)
+		}
+	}
+	return kept
+}
+
+// SingleTarget returns the endpoints cut to their first target, as
+// usg-dns-api holds a single record per name, along with a warning for
+// each endpoint which had more. Endpoints with several targets are copied
+// rather than modified.
+func SingleTarget(endpoints []*webhook.Endpoint) ([]*webhook.Endpoint, []string) {
+	var warnings []string
+	single := make([]*webhook.Endpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if len(endpoint.Targets) > 1 {
+			warnings = append(warnings, fmt.Sprintf("%s has several targets, keeping %s and dropping %s",
+				endpoint.DNSName, endpoint.Targets[0], strings.Join(endpoint.Targets[1:], ", ")))
+			cut := *endpoint
+			cut.Targets = endpoint.Targets[:1]
+			endpoint = &cut
+		}
+		single = append(single, endpoint)
+	}
+	return single, warnings
+}
+
+// byName indexes endpoints by name. When a name appears more than once the
+// last endpoint wins, as usg-dns-api holds a single record per name.
+func byName(endpoints []*webhook.Endpoint) map[string]*webhook.Endpoint {
+	m := make(map[string]*webhook.Endpoint, len(endpoints))
+	for _, endpoint := range endpoints {
+		m[endpoint.DNSName] = endpoint
+	}
+	return m
+}
+
+func sortedNames(m map[string]*webhook.Endpoint) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sameTargets(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	sort.Strings(a)
+	sort.Strings(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,90 @@
+package diff
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+func endpoint(name string, targets ...string) *webhook.Endpoint {
+	return &webhook.Endpoint{DNSName: name, Targets: targets, RecordType: "A"}
+}
+
+func TestCompute(t *testing.T) {
+	current := []*webhook.Endpoint{
+		endpoint("same.example.com", "1.1.1.1", "2.2.2.2"),
+		endpoint("changed.example.com", "1.1.1.1"),
+		endpoint("gone.example.com", "1.1.1.1"),
+	}
+	desired := []*webhook.Endpoint{
+		endpoint("same.example.com", "2.2.2.2", "1.1.1.1"),
+		endpoint("changed.example.com", "3.3.3.3"),
+		endpoint("new-b.example.com", "4.4.4.4"),
+		endpoint("new-a.example.com", "5.5.5.5"),
+	}
+
+	changes := Compute(current, desired)
+
+	if len(changes.Create) != 2 || changes.Create[0].DNSName != "new-a.example.com" || changes.Create[1].DNSName != "new-b.example.com" {
+		t.Errorf("Expected sorted creates, got %+v", changes.Create)
+	}
+	if len(changes.UpdateNew) != 1 || changes.UpdateOld[0].Targets[0] != "1.1.1.1" || changes.UpdateNew[0].Targets[0] != "3.3.3.3" {
+		t.Errorf("Unexpected updates: %+v -> %+v", changes.UpdateOld, changes.UpdateNew)
+	}
+	if len(changes.Delete) != 1 || changes.Delete[0].DNSName != "gone.example.com" {
+		t.Errorf("Unexpected deletes: %+v", changes.Delete)
+	}
+
+	if !Compute(desired, desired).IsEmpty() {
+		t.Error("Expected no changes between identical sets")
+	}
+}
+
+func TestSingleTarget(t *testing.T) {
+	multi := endpoint("rr.example.com", "1.1.1.1", "2.2.2.2", "3.3.3.3")
+	endpoints, warnings := SingleTarget([]*webhook.Endpoint{endpoint("one.example.com", "4.4.4.4"), multi})
+
+	if len(endpoints) != 2 || len(endpoints[1].Targets) != 1 || endpoints[1].Targets[0] != "1.1.1.1" {
+		t.Errorf("Expected the endpoints cut to their first target, got %+v", endpoints)
+	}
+	if len(warnings) != 1 || warnings[0] != "rr.example.com has several targets, keeping 1.1.1.1 and dropping 2.2.2.2, 3.3.3.3" {
+		t.Errorf("Expected a warning for the dropped targets, got %v", warnings)
+	}
+	if len(multi.Targets) != 3 {
+		t.Error("Expected the original endpoint to be left alone")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	current := []*webhook.Endpoint{
+		endpoint("same.example.com", "1.1.1.1"),
+		endpoint("conflict.example.com", "1.1.1.1"),
+		endpoint("untouched.example.com", "1.1.1.1"),
+	}
+	incoming := []*webhook.Endpoint{
+		endpoint("same.example.com", "1.1.1.1"),
+		endpoint("conflict.example.com", "2.2.2.2"),
+		endpoint("new.example.com", "3.3.3.3"),
+	}
+
+	changes, conflicts, err := Merge(current, incoming, ConflictSkip)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if len(conflicts) != 1 || len(changes.Create) != 1 || len(changes.UpdateNew) != 0 || len(changes.Delete) != 0 {
+		t.Errorf("Expected a single create and a skipped conflict, got %+v, %+v", changes, conflicts)
+	}
+
+	changes, _, err = Merge(current, incoming, ConflictOverwrite)
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if len(changes.UpdateNew) != 1 || changes.UpdateNew[0].Targets[0] != "2.2.2.2" || len(changes.Delete) != 0 {
+		t.Errorf("Expected the conflict to be overwritten, got %+v", changes)
+	}
+
+	if _, conflicts, err := Merge(current, incoming, ConflictFail); !errors.Is(err, ErrConflict) || len(conflicts) != 1 {
+		t.Errorf("Expected ErrConflict, got %v", err)
+	}
+}
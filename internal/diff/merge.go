@@ -0,0 +1,71 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// Conflict strategies of Merge
+const (
+	// ConflictSkip leaves the existing record alone
+	ConflictSkip = "skip"
+	// ConflictOverwrite replaces the targets of the existing record
+	ConflictOverwrite = "overwrite"
+	// ConflictFail aborts the whole merge
+	ConflictFail = "fail"
+)
+
+// ConflictStrategies lists the conflict strategies of Merge
+var ConflictStrategies = []string{ConflictSkip, ConflictOverwrite, ConflictFail}
+
+// ErrConflict is returned by Merge with the fail strategy when an incoming
+// endpoint conflicts with an existing record
+var ErrConflict = errors.New("incoming records conflict with existing ones")
+
+// Conflict is an incoming endpoint whose name already exists with other
+// targets
+type Conflict struct {
+	Name     string   `json:"name"`
+	Current  []string `json:"current"`
+	Incoming []string `json:"incoming"`
+}
+
+// Merge returns the changes adding incoming to current. Unlike Compute, it
+// never deletes anything: names which only exist in current are left
+// alone, and names which exist in both with other targets are handled
+// according to strategy.
+func Merge(current, incoming []*webhook.Endpoint, strategy string) (*webhook.Changes, []Conflict, error) {
+	switch strategy {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+	default:
+		return nil, nil, fmt.Errorf("unknown conflict strategy %q", strategy)
+	}
+
+	currentByName := byName(current)
+	incomingByName := byName(incoming)
+
+	changes := &webhook.Changes{}
+	var conflicts []Conflict
+	for _, name := range sortedNames(incomingByName) {
+		want := incomingByName[name]
+		have, ok := currentByName[name]
+		switch {
+		case !ok:
+			changes.Create = append(changes.Create, want)
+		case !sameTargets(have.Targets, want.Targets):
+			conflicts = append(conflicts, Conflict{Name: name, Current: have.Targets, Incoming: want.Targets})
+			if strategy == ConflictOverwrite {
+				changes.UpdateOld = append(changes.UpdateOld, have)
+				changes.UpdateNew = append(changes.UpdateNew, want)
+			}
+		}
+	}
+
+	if strategy == ConflictFail && len(conflicts) > 0 {
+		return nil, conflicts, fmt.Errorf("%w: %d names", ErrConflict, len(conflicts))
+	}
+
+	return changes, conflicts, nil
+}
@@ -0,0 +1,129 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+)
+
+// Event types
+const (
+	// TypeChangeApplied is published when a batch of changes was applied,
+	// or received in dry-run mode
+	TypeChangeApplied = "change.applied"
+	// TypeChangeFailed is published when a batch of changes failed
+	TypeChangeFailed = "change.failed"
+	// TypeDriftDetected is published when records within the domain filter
+	// were changed on the gateway by someone else
+	TypeDriftDetected = "drift.detected"
+	// TypeBackendHealth is published when the gateway becomes reachable or
+	// unreachable
+	TypeBackendHealth = "backend.health"
+	// TypeConfigReloaded is published when the configuration was reloaded
+	TypeConfigReloaded = "config.reloaded"
+	// TypeConfigRejected is published when a reloaded configuration was
+	// invalid and the running one was kept
+	TypeConfigRejected = "config.rejected"
+)
+
+// subscriberBuffer is the number of events a subscriber may lag behind
+// before it is disconnected
+const subscriberBuffer = 64
+
+// Event is a typed event with a monotonic ID
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// Broker fans events out to subscribers and keeps the latest ones so that
+// clients can resume after a disconnection
+type Broker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	replay      []Event
+	replaySize  int
+	subscribers map[chan Event]struct{}
+}
+
+// NewBroker creates a broker keeping the last replaySize events
+func NewBroker(replaySize int) *Broker {
+	return &Broker{
+		replaySize:  replaySize,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// Publish sends an event to every subscriber. Subscribers too slow to keep
+// up are disconnected, they can resume from the replay buffer.
+func (b *Broker) Publish(eventType string, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{
+		ID:   b.lastID,
+		Type: eventType,
+		Time: time.Now(),
+		Data: data,
+	}
+
+	b.replay = append(b.replay, event)
+	if len(b.replay) > b.replaySize {
+		b.replay = b.replay[len(b.replay)-b.replaySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return event
+}
+
+// Subscribe returns the buffered events published after lastID and a
+// channel receiving the next ones. The channel is closed when the
+// subscriber falls behind or cancel is called.
+func (b *Broker) Subscribe(lastID uint64) ([]Event, <-chan Event, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var missed []Event
+	for _, event := range b.replay {
+		if event.ID > lastID {
+			missed = append(missed, event)
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	cancel := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return missed, ch, cancel
+}
+
+// ObserveBatch implements provider.Observer
+func (b *Broker) ObserveBatch(_ context.Context, batch provider.Batch) {
+	if batch.Failed() {
+		b.Publish(TypeChangeFailed, batch)
+		return
+	}
+	b.Publish(TypeChangeApplied, batch)
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+func TestBrokerReplay(t *testing.T) {
+	b := NewBroker(2)
+	b.Publish(TypeChangeApplied, nil)
+	b.Publish(TypeChangeFailed, nil)
+	b.Publish(TypeBackendHealth, nil)
+
+	missed, ch, cancel := b.Subscribe(0)
+	if len(missed) != 2 || missed[0].ID != 2 || missed[1].ID != 3 {
+		t.Fatalf("Expected events 2 and 3 to be replayed, got %+v", missed)
+	}
+
+	b.Publish(TypeDriftDetected, nil)
+	if event := <-ch; event.ID != 4 || event.Type != TypeDriftDetected {
+		t.Errorf("Expected event 4, got %+v", event)
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Error("Expected channel to be closed after cancel")
+	}
+
+	missed, _, cancel = b.Subscribe(3)
+	defer cancel()
+	if len(missed) != 1 || missed[0].ID != 4 {
+		t.Errorf("Expected only event 4 to be replayed, got %+v", missed)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := map[string]usgdns.Record{
+		"a.example.com": {Name: "a.example.com", Target: "1.2.3.4"},
+		"b.example.com": {Name: "b.example.com", Target: "1.2.3.5"},
+	}
+	if drift := diff(before, before); drift != nil {
+		t.Errorf("Expected no drift, got %+v", drift)
+	}
+
+	after := map[string]usgdns.Record{
+		"a.example.com": {Name: "a.example.com", Target: "1.2.3.9"},
+		"c.example.com": {Name: "c.example.com", Target: "1.2.3.6"},
+	}
+	drift := diff(before, after)
+	if drift == nil {
+		t.Fatal("Expected drift")
+	}
+	if len(drift.Added) != 1 || drift.Added[0].Name != "c.example.com" {
+		t.Errorf("Unexpected added records: %+v", drift.Added)
+	}
+	if len(drift.Removed) != 1 || drift.Removed[0].Name != "b.example.com" {
+		t.Errorf("Unexpected removed records: %+v", drift.Removed)
+	}
+	if len(drift.Changed) != 1 || drift.Changed[0].NewTarget != "1.2.3.9" {
+		t.Errorf("Unexpected changed records: %+v", drift.Changed)
+	}
+}
@@ -0,0 +1,171 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// Health is the data of a backend.health event
+type Health struct {
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Drift is the data of a drift.detected event
+type Drift struct {
+	Added   []usgdns.Record `json:"added,omitempty"`
+	Removed []usgdns.Record `json:"removed,omitempty"`
+	Changed []TargetChange  `json:"changed,omitempty"`
+}
+
+// TargetChange is a record whose target changed on the gateway
+type TargetChange struct {
+	Name      string `json:"name"`
+	OldTarget string `json:"oldTarget"`
+	NewTarget string `json:"newTarget"`
+}
+
+// Monitor polls the gateway and publishes drift and health events
+type Monitor struct {
+	provider *provider.Provider
+	broker   *Broker
+	interval time.Duration
+
+	mu       sync.Mutex
+	baseline map[string]usgdns.Record
+	healthy  *bool
+
+	// generation is incremented for each batch we apply, so that a poll
+	// overlapping with one of our batches is not mistaken for drift
+	generation uint64
+}
+
+// NewMonitor creates a monitor polling every interval
+func NewMonitor(prov *provider.Provider, broker *Broker, interval time.Duration) *Monitor {
+	return &Monitor{
+		provider: prov,
+		broker:   broker,
+		interval: interval,
+	}
+}
+
+// Run polls the gateway until ctx is done
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.poll(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.poll(ctx)
+		}
+	}
+}
+
+// ObserveBatch implements provider.Observer. Changes we make ourselves are
+// not drift, so the baseline is dropped and taken again on the next poll.
+func (m *Monitor) ObserveBatch(_ context.Context, batch provider.Batch) {
+	if batch.DryRun {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.baseline = nil
+	m.generation++
+}
+
+func (m *Monitor) poll(ctx context.Context) {
+	m.mu.Lock()
+	generation := m.generation
+	m.mu.Unlock()
+
+	records, err := m.provider.RefreshCache(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setHealth(err)
+	if err != nil {
+		return
+	}
+
+	if m.generation != generation {
+		m.baseline = nil
+		return
+	}
+
+	current := make(map[string]usgdns.Record, len(records))
+	for _, record := range records {
+		if m.provider.Manages(record.Name) {
+			current[record.Name] = record
+		}
+	}
+
+	if m.baseline != nil {
+		if drift := diff(m.baseline, current); drift != nil {
+			log.Printf("Drift detected on the gateway: %d added, %d removed, %d changed",
+				len(drift.Added), len(drift.Removed), len(drift.Changed))
+			m.broker.Publish(TypeDriftDetected, drift)
+		}
+	}
+	m.baseline = current
+}
+
+// setHealth publishes an event when the health of the gateway changes
+func (m *Monitor) setHealth(err error) {
+	healthy := err == nil
+	if m.healthy != nil && *m.healthy == healthy {
+		return
+	}
+	m.healthy = &healthy
+
+	health := Health{Healthy: healthy}
+	if err != nil {
+		health.Error = err.Error()
+		log.Printf("Gateway is unhealthy: %v", err)
+	} else {
+		log.Printf("Gateway is healthy")
+	}
+	m.broker.Publish(TypeBackendHealth, health)
+}
+
+// diff compares two inventories keyed by name, returning nil when they match
+func diff(before, after map[string]usgdns.Record) *Drift {
+	drift := &Drift{}
+
+	for name, record := range after {
+		old, ok := before[name]
+		switch {
+		case !ok:
+			drift.Added = append(drift.Added, record)
+		case old.Target != record.Target:
+			drift.Changed = append(drift.Changed, TargetChange{Name: name, OldTarget: old.Target, NewTarget: record.Target})
+		}
+	}
+	for name, record := range before {
+		if _, ok := after[name]; !ok {
+			drift.Removed = append(drift.Removed, record)
+		}
+	}
+
+	if len(drift.Added)+len(drift.Removed)+len(drift.Changed) == 0 {
+		return nil
+	}
+
+	sort.Slice(drift.Added, func(i, j int) bool { return drift.Added[i].Name < drift.Added[j].Name })
+	sort.Slice(drift.Removed, func(i, j int) bool { return drift.Removed[i].Name < drift.Removed[j].Name })
+	sort.Slice(drift.Changed, func(i, j int) bool { return drift.Changed[i].Name < drift.Changed[j].Name })
+
+	return drift
+}
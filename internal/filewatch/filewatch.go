@@ -0,0 +1,67 @@
+package filewatch
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// DefaultInterval is the polling interval used when none is specified
+const DefaultInterval = 10 * time.Second
+
+// fileState captures what we compare between two polls
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// Watch polls the given files and calls onChange whenever one of them is
+// modified, created or removed. It blocks until ctx is done.
+//
+// Polling is used instead of inotify so that atomic symlink swaps done by
+// Kubernetes when updating mounted Secrets and ConfigMaps are detected.
+func Watch(ctx context.Context, interval time.Duration, onChange func(), paths ...string) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	states := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		states[path] = stat(path)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed := false
+			for _, path := range paths {
+				state := stat(path)
+				if state != states[path] {
+					states[path] = state
+					changed = true
+				}
+			}
+			if changed {
+				onChange()
+			}
+		}
+	}
+}
+
+func stat(path string) fileState {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		exists:  true,
+	}
+}
@@ -0,0 +1,225 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// Supported formats
+const (
+	Zone    = "zone"
+	Hosts   = "hosts"
+	Dnsmasq = "dnsmasq"
+	JSON    = "json"
+	YAML    = "yaml"
+)
+
+// Formats lists the supported formats
+var Formats = []string{Zone, Hosts, Dnsmasq, JSON, YAML}
+
+// defaultTTL is used for endpoints without a TTL
+const defaultTTL = 300
+
+// ZoneRecords holds the endpoints belonging to a zone. Endpoints outside
+// of every zone are grouped under the empty name.
+type ZoneRecords struct {
+	Name      string
+	Endpoints []*webhook.Endpoint
+}
+
+// ContentType returns the media type of format
+func ContentType(format string) string {
+	switch format {
+	case JSON:
+		return "application/json"
+	case YAML:
+		return "application/yaml"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Render writes endpoints in format. Output is sorted by name and target so
+// that it is identical for identical records. In the zone format, endpoints
+// are grouped by zone, each zone starting with its own $ORIGIN.
+func Render(w io.Writer, format string, endpoints []*webhook.Endpoint, zones []string) error {
+	endpoints = sorted(endpoints)
+
+	switch format {
+	case Zone:
+		for i, zone := range SplitZones(endpoints, zones) {
+			if i > 0 {
+				if _, err := fmt.Fprintln(w); err != nil {
+					return err
+				}
+			}
+			if err := RenderZone(w, zone); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Hosts:
+		return renderHosts(w, endpoints)
+	case Dnsmasq:
+		return renderDnsmasq(w, endpoints)
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(nonNil(endpoints))
+	case YAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(nonNil(endpoints)); err != nil {
+			return err
+		}
+		return encoder.Close()
+	default:
+		return fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+	}
+}
+
+// SplitZones groups endpoints by the longest zone of zones containing them,
+// in the order of the zone names
+func SplitZones(endpoints []*webhook.Endpoint, zones []string) []ZoneRecords {
+	byZone := make(map[string][]*webhook.Endpoint)
+	for _, endpoint := range sorted(endpoints) {
+		zone := zoneOf(endpoint.DNSName, zones)
+		byZone[zone] = append(byZone[zone], endpoint)
+	}
+
+	names := make([]string, 0, len(byZone))
+	for name := range byZone {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	split := make([]ZoneRecords, 0, len(names))
+	for _, name := range names {
+		split = append(split, ZoneRecords{Name: name, Endpoints: byZone[name]})
+	}
+	return split
+}
+
+// RenderZone writes the records of a zone in the RFC 1035 master file
+// format. Only resource records are written: the SOA and NS records are
+// managed elsewhere.
+func RenderZone(w io.Writer, zone ZoneRecords) error {
+	if zone.Name != "" {
+		if _, err := fmt.Fprintf(w, "$ORIGIN %s.\n", zone.Name); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "$TTL %d\n", defaultTTL); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, endpoint := range zone.Endpoints {
+		owner := relativeName(endpoint.DNSName, zone.Name)
+		for _, target := range endpoint.Targets {
+			fmt.Fprintf(tw, "%s\t%d\tIN\t%s\t%s\n", owner, ttl(endpoint), recordType(endpoint), target)
+		}
+	}
+	return tw.Flush()
+}
+
+func renderHosts(w io.Writer, endpoints []*webhook.Endpoint) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, endpoint := range endpoints {
+		// Wildcards cannot be expressed in a hosts file
+		if strings.HasPrefix(endpoint.DNSName, "*.") {
+			continue
+		}
+		for _, target := range endpoint.Targets {
+			fmt.Fprintf(tw, "%s\t%s\n", target, endpoint.DNSName)
+		}
+	}
+	return tw.Flush()
+}
+
+func renderDnsmasq(w io.Writer, endpoints []*webhook.Endpoint) error {
+	for _, endpoint := range endpoints {
+		for _, target := range endpoint.Targets {
+			var err error
+			if domain, ok := strings.CutPrefix(endpoint.DNSName, "*."); ok {
+				// address= also answers for every name below the domain
+				_, err = fmt.Fprintf(w, "address=/%s/%s\n", domain, target)
+			} else {
+				_, err = fmt.Fprintf(w, "host-record=%s,%s,%d\n", endpoint.DNSName, target, ttl(endpoint))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sorted returns copies of endpoints sorted by name, with sorted targets
+func sorted(endpoints []*webhook.Endpoint) []*webhook.Endpoint {
+	copies := make([]*webhook.Endpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		c := *endpoint
+		c.DNSName = strings.TrimSuffix(c.DNSName, ".")
+		c.Targets = slices.Clone(c.Targets)
+		sort.Strings(c.Targets)
+		copies = append(copies, &c)
+	}
+	sort.SliceStable(copies, func(i, j int) bool { return copies[i].DNSName < copies[j].DNSName })
+	return copies
+}
+
+// zoneOf returns the longest zone containing name, or the empty string
+func zoneOf(name string, zones []string) string {
+	var best string
+	for _, zone := range zones {
+		zone = strings.TrimSuffix(strings.TrimPrefix(zone, "."), ".")
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// relativeName returns name relative to zone, or absolute outside of zones
+func relativeName(name, zone string) string {
+	switch {
+	case zone == "":
+		return name + "."
+	case name == zone:
+		return "@"
+	default:
+		return strings.TrimSuffix(name, "."+zone)
+	}
+}
+
+func ttl(endpoint *webhook.Endpoint) int64 {
+	if endpoint.RecordTTL > 0 {
+		return endpoint.RecordTTL
+	}
+	return defaultTTL
+}
+
+func recordType(endpoint *webhook.Endpoint) string {
+	if endpoint.RecordType != "" {
+		return endpoint.RecordType
+	}
+	return "A"
+}
+
+// nonNil makes empty lists encode as [] rather than null
+func nonNil(endpoints []*webhook.Endpoint) []*webhook.Endpoint {
+	if endpoints == nil {
+		return []*webhook.Endpoint{}
+	}
+	return endpoints
+}
@@ -0,0 +1,152 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+var testEndpoints = []*webhook.Endpoint{
+	{DNSName: "www.example.com", Targets: []string{"192.168.1.11", "192.168.1.10"}, RecordType: "A", RecordTTL: 300},
+	{DNSName: "example.com", Targets: []string{"192.168.1.1"}, RecordType: "A", RecordTTL: 600},
+	{DNSName: "*.apps.lab.example.com", Targets: []string{"192.168.2.1"}, RecordType: "A"},
+	{DNSName: "nas.other.org", Targets: []string{"10.0.0.2"}, RecordType: "A"},
+}
+
+func render(t *testing.T, format string, zones []string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Render(&buf, format, testEndpoints, zones); err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	return buf.String()
+}
+
+func TestRenderZone(t *testing.T) {
+	expected := `$TTL 300
+nas.other.org. 300 IN A 10.0.0.2
+
+$ORIGIN example.com.
+$TTL 300
+@   600 IN A 192.168.1.1
+www 300 IN A 192.168.1.10
+www 300 IN A 192.168.1.11
+
+$ORIGIN lab.example.com.
+$TTL 300
+*.apps 300 IN A 192.168.2.1
+`
+	if got := render(t, Zone, []string{"example.com", "lab.example.com"}); got != expected {
+		t.Errorf("Unexpected zone output:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestRenderHostsAndDnsmasq(t *testing.T) {
+	expectedHosts := `192.168.1.1  example.com
+10.0.0.2     nas.other.org
+192.168.1.10 www.example.com
+192.168.1.11 www.example.com
+`
+	if got := render(t, Hosts, nil); got != expectedHosts {
+		t.Errorf("Unexpected hosts output:\n%s", got)
+	}
+
+	expectedDnsmasq := `address=/apps.lab.example.com/192.168.2.1
+host-record=example.com,192.168.1.1,600
+host-record=nas.other.org,10.0.0.2,300
+host-record=www.example.com,192.168.1.10,300
+host-record=www.example.com,192.168.1.11,300
+`
+	if got := render(t, Dnsmasq, nil); got != expectedDnsmasq {
+		t.Errorf("Unexpected dnsmasq output:\n%s", got)
+	}
+}
+
+func TestRenderIsDeterministic(t *testing.T) {
+	for _, format := range Formats {
+		first := render(t, format, []string{"example.com"})
+		testEndpoints[0], testEndpoints[3] = testEndpoints[3], testEndpoints[0]
+		if second := render(t, format, []string{"example.com"}); first != second {
+			t.Errorf("Expected %s output not to depend on the input order", format)
+		}
+	}
+
+	if err := Render(&bytes.Buffer{}, "csv", testEndpoints, nil); err == nil {
+		t.Error("Expected unknown format to be rejected")
+	}
+}
+
+func TestParseZone(t *testing.T) {
+	zone := `$ORIGIN example.com.
+$TTL 600
+@	IN SOA ns1 hostmaster (
+		2026101901 ; serial
+		3600 600 604800 300 )
+	IN NS ns1
+www	300 IN A 192.168.1.10
+	IN A 192.168.1.11 ; second address of www
+mail	IN 120 A 192.168.1.20
+alias	IN CNAME www
+nas.other.org. A 10.0.0.2
+`
+	endpoints, warnings, err := Parse(strings.NewReader(zone), Zone)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "CNAME") {
+		t.Errorf("Expected a warning for the CNAME record, got %v", warnings)
+	}
+	if len(endpoints) != 3 {
+		t.Fatalf("Expected 3 endpoints, got %d", len(endpoints))
+	}
+	if www := endpoints[0]; www.DNSName != "www.example.com" || len(www.Targets) != 2 || www.RecordTTL != 300 {
+		t.Errorf("Unexpected endpoint: %+v", www)
+	}
+	if mail := endpoints[1]; mail.DNSName != "mail.example.com" || mail.RecordTTL != 120 {
+		t.Errorf("Unexpected endpoint: %+v", mail)
+	}
+	if nas := endpoints[2]; nas.DNSName != "nas.other.org" || nas.RecordTTL != 600 {
+		t.Errorf("Unexpected endpoint: %+v", nas)
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	hosts := `127.0.0.1 localhost
+::1 localhost ip6-localhost
+# legacy hosts
+192.168.1.10 www.example.com www  # web server
+192.168.1.20 mail.example.com
+`
+	endpoints, warnings, err := Parse(strings.NewReader(hosts), Hosts)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("Expected loopback and IPv6 entries to be reported, got %v", warnings)
+	}
+	if len(endpoints) != 3 || endpoints[0].DNSName != "www.example.com" || endpoints[1].DNSName != "www" {
+		t.Errorf("Unexpected endpoints: %+v", endpoints)
+	}
+
+	if _, _, err := Parse(strings.NewReader("not-an-ip host\n"), Hosts); err == nil {
+		t.Error("Expected invalid address to be rejected")
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{Zone, JSON, YAML} {
+		var buf bytes.Buffer
+		if err := Render(&buf, format, testEndpoints, []string{"example.com"}); err != nil {
+			t.Fatalf("Render failed: %v", err)
+		}
+		endpoints, _, err := Parse(&buf, format)
+		if err != nil {
+			t.Fatalf("Parse of %s failed: %v", format, err)
+		}
+		if len(endpoints) != len(testEndpoints) {
+			t.Errorf("Expected %d endpoints from %s, got %d", len(testEndpoints), format, len(endpoints))
+		}
+	}
+}
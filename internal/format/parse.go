@@ -0,0 +1,242 @@
+package format
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// ParseFormats lists the formats supported by Parse
+var ParseFormats = []string{Zone, Hosts, JSON, YAML}
+
+// Parse reads endpoints in format. Only A records can be held by
+// usg-dns-api, the other entries are skipped and reported as warnings.
+// Entries sharing a name are merged into a single endpoint.
+func Parse(r io.Reader, format string) ([]*webhook.Endpoint, []string, error) {
+	switch format {
+	case Zone:
+		return parseZone(r)
+	case Hosts:
+		return parseHosts(r)
+	case JSON:
+		var endpoints []*webhook.Endpoint
+		if err := json.NewDecoder(r).Decode(&endpoints); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON endpoint list: %w", err)
+		}
+		return endpoints, nil, nil
+	case YAML:
+		var endpoints []*webhook.Endpoint
+		if err := yaml.NewDecoder(r).Decode(&endpoints); err != nil && err != io.EOF {
+			return nil, nil, fmt.Errorf("invalid YAML endpoint list: %w", err)
+		}
+		return endpoints, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(ParseFormats, ", "))
+	}
+}
+
+// merger collects A records into endpoints, in order of appearance
+type merger struct {
+	endpoints []*webhook.Endpoint
+	byName    map[string]*webhook.Endpoint
+}
+
+func (m *merger) add(name, target string, ttl int64) {
+	if m.byName == nil {
+		m.byName = make(map[string]*webhook.Endpoint)
+	}
+	if endpoint, ok := m.byName[name]; ok {
+		endpoint.Targets = append(endpoint.Targets, target)
+		return
+	}
+	endpoint := &webhook.Endpoint{DNSName: name, Targets: []string{target}, RecordType: "A", RecordTTL: ttl}
+	m.byName[name] = endpoint
+	m.endpoints = append(m.endpoints, endpoint)
+}
+
+func parseHosts(r io.Reader) ([]*webhook.Endpoint, []string, error) {
+	var m merger
+	var warnings []string
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, nil, fmt.Errorf("line %d: expected an address followed by names", line)
+		}
+
+		ip := net.ParseIP(fields[0])
+		switch {
+		case ip == nil:
+			return nil, nil, fmt.Errorf("line %d: invalid address %q", line, fields[0])
+		case ip.To4() == nil:
+			warnings = append(warnings, fmt.Sprintf("line %d: skipped IPv6 address %s", line, fields[0]))
+			continue
+		case ip.IsLoopback() || ip.IsUnspecified():
+			warnings = append(warnings, fmt.Sprintf("line %d: skipped local address %s", line, fields[0]))
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			m.add(strings.TrimSuffix(strings.ToLower(name), "."), fields[0], 0)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return m.endpoints, warnings, nil
+}
+
+// parseZone reads the records of an RFC 1035 master file. $INCLUDE and
+// $GENERATE are not supported.
+func parseZone(r io.Reader) ([]*webhook.Endpoint, []string, error) {
+	var m merger
+	var warnings []string
+	var origin, owner string
+	var defaultTTL int64
+
+	lines, err := zoneLines(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, zl := range lines {
+		fields := zl.fields
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, nil, fmt.Errorf("line %d: $ORIGIN without a domain", zl.line)
+			}
+			origin = absoluteName(fields[1], origin)
+			continue
+		case "$TTL":
+			if len(fields) < 2 {
+				return nil, nil, fmt.Errorf("line %d: $TTL without a value", zl.line)
+			}
+			ttl, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: invalid $TTL: %w", zl.line, err)
+			}
+			defaultTTL = ttl
+			continue
+		case "$INCLUDE", "$GENERATE":
+			return nil, nil, fmt.Errorf("line %d: %s is not supported", zl.line, fields[0])
+		}
+
+		// A line starting with a blank reuses the previous owner
+		if !zl.continued {
+			owner = absoluteName(fields[0], origin)
+			fields = fields[1:]
+		}
+		if owner == "" {
+			return nil, nil, fmt.Errorf("line %d: record without an owner", zl.line)
+		}
+
+		// The TTL and the class are optional and may come in any order
+		ttl := defaultTTL
+		for len(fields) > 0 {
+			if value, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				ttl = value
+			} else if class := strings.ToUpper(fields[0]); class != "IN" && class != "CH" && class != "HS" {
+				break
+			}
+			fields = fields[1:]
+		}
+		if len(fields) < 2 {
+			return nil, nil, fmt.Errorf("line %d: expected a type and data", zl.line)
+		}
+
+		recordType := strings.ToUpper(fields[0])
+		if recordType != "A" {
+			if recordType != "SOA" && recordType != "NS" {
+				warnings = append(warnings, fmt.Sprintf("line %d: skipped %s record %s", zl.line, recordType, owner))
+			}
+			continue
+		}
+		if ip := net.ParseIP(fields[1]); ip == nil || ip.To4() == nil {
+			return nil, nil, fmt.Errorf("line %d: invalid IPv4 address %q", zl.line, fields[1])
+		}
+
+		m.add(owner, fields[1], ttl)
+	}
+
+	return m.endpoints, warnings, nil
+}
+
+// zoneLine is a logical line of a zone file
+type zoneLine struct {
+	line      int
+	fields    []string
+	continued bool
+}
+
+// zoneLines splits a zone file into logical lines, dropping comments and
+// joining the lines enclosed in parentheses
+func zoneLines(r io.Reader) ([]zoneLine, error) {
+	var lines []zoneLine
+	var current *zoneLine
+	depth := 0
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		raw := scanner.Text()
+		text, _, _ := strings.Cut(raw, ";")
+		depth += strings.Count(text, "(") - strings.Count(text, ")")
+		text = strings.NewReplacer("(", " ", ")", " ").Replace(text)
+
+		fields := strings.Fields(text)
+		if current != nil {
+			current.fields = append(current.fields, fields...)
+		} else if len(fields) > 0 {
+			current = &zoneLine{
+				line:      line,
+				fields:    fields,
+				continued: raw[0] == ' ' || raw[0] == '\t',
+			}
+		}
+
+		if depth < 0 {
+			return nil, fmt.Errorf("line %d: unbalanced parentheses", line)
+		}
+		if depth == 0 && current != nil {
+			lines = append(lines, *current)
+			current = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses at the end of the zone")
+	}
+
+	return lines, nil
+}
+
+// absoluteName resolves a name of a zone file against origin, returning it
+// without the trailing dot
+func absoluteName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.ToLower(strings.TrimSuffix(name, "."))
+	case origin == "":
+		return strings.ToLower(name)
+	default:
+		return strings.ToLower(name) + "." + origin
+	}
+}
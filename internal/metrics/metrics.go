@@ -0,0 +1,120 @@
+// Package metrics serves metrics in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	samples func() []Sample
+}
+
+// Sample is a value of a metric, told apart from the other values of the
+// metric by its labels
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Registry holds metrics whose values are read when they are scraped
+type Registry struct {
+	mu      sync.RWMutex
+	metrics []metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Counter registers a counter, a value which only goes up
+func (r *Registry) Counter(name, help string, value func() float64) {
+	r.CounterVec(name, help, single(value))
+}
+
+// Gauge registers a gauge, a value which can go up and down
+func (r *Registry) Gauge(name, help string, value func() float64) {
+	r.GaugeVec(name, help, single(value))
+}
+
+// CounterVec registers a counter with a value per set of labels
+func (r *Registry) CounterVec(name, help string, samples func() []Sample) {
+	r.register(metric{name: name, help: help, kind: typeCounter, samples: samples})
+}
+
+// GaugeVec registers a gauge with a value per set of labels
+func (r *Registry) GaugeVec(name, help string, samples func() []Sample) {
+	r.register(metric{name: name, help: help, kind: typeGauge, samples: samples})
+}
+
+// single turns a value into the samples of a metric without labels
+func single(value func() float64) func() []Sample {
+	return func() []Sample {
+		return []Sample{{Value: value()}}
+	}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP writes every metric in the text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	w.Header().Set("Content-Type", contentType)
+	for _, m := range r.metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		for _, sample := range m.samples() {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(sample.Labels), strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		}
+	}
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats labels sorted by name, as {name="value",...}
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
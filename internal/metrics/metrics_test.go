@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistryServeHTTP(t *testing.T) {
+	registry := NewRegistry()
+	count := 3.0
+	registry.Counter("requests_total", "Requests sent.", func() float64 { return count })
+	registry.Gauge("state", "Current state.", func() float64 { return 0.5 })
+
+	count++
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `# HELP requests_total Requests sent.
+# TYPE requests_total counter
+requests_total 4
+# HELP state Current state.
+# TYPE state gauge
+state 0.5
+`
+	if rec.Body.String() != want {
+		t.Errorf("Unexpected output:\n%s", rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != contentType {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+}
+
+func TestRegistryLabels(t *testing.T) {
+	registry := NewRegistry()
+	registry.GaugeVec("up", "Whether the backend is up.", func() []Sample {
+		return []Sample{
+			{Labels: map[string]string{"url": "http://a", "role": "primary"}, Value: 1},
+			{Labels: map[string]string{"url": `http://"b"`}, Value: 0},
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `# HELP up Whether the backend is up.
+# TYPE up gauge
+up{role="primary",url="http://a"} 1
+up{url="http://\"b\""} 0
+`
+	if rec.Body.String() != want {
+		t.Errorf("Unexpected output:\n%s", rec.Body.String())
+	}
+}
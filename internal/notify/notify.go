@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+)
+
+// Event types
+const (
+	// EventApplied is emitted when a batch of changes was fully applied
+	EventApplied = "changes.applied"
+	// EventFailed is emitted when a batch of changes could not be fully applied
+	EventFailed = "changes.failed"
+	// EventDryRun is emitted when a batch of changes was received in dry-run mode
+	EventDryRun = "changes.dry_run"
+)
+
+// queueSize is the number of events waiting for delivery before new ones
+// are dropped
+const queueSize = 100
+
+// Event describes a batch of changes handled by the provider
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"requestId,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Created   []Change  `json:"created,omitempty"`
+	Updated   []Change  `json:"updated,omitempty"`
+	Deleted   []Change  `json:"deleted,omitempty"`
+}
+
+// Change is a single change of an event, with its outcome
+type Change struct {
+	Name    string                `json:"name"`
+	Targets []string              `json:"targets,omitempty"`
+	Status  provider.ChangeStatus `json:"status"`
+	Reason  string                `json:"reason,omitempty"`
+}
+
+// Sink delivers events to a destination
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, event Event) error
+}
+
+// Filter selects the events and the names sent to the sinks. Empty fields
+// select everything.
+type Filter struct {
+	Domains []string
+	Events  []string
+}
+
+// Notifier turns the batches observed on the provider into events and
+// delivers them to the sinks in the background
+type Notifier struct {
+	sinks  []Sink
+	filter Filter
+	queue  chan Event
+	wg     sync.WaitGroup
+}
+
+// New creates a notifier
+func New(filter Filter, sinks ...Sink) *Notifier {
+	return &Notifier{
+		sinks:  sinks,
+		filter: filter,
+		queue:  make(chan Event, queueSize),
+	}
+}
+
+// Start delivers queued events until ctx is done
+func (n *Notifier) Start(ctx context.Context) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-n.queue:
+				n.deliver(ctx, event)
+			}
+		}
+	}()
+}
+
+// Wait blocks until the delivery goroutine has stopped
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// ObserveBatch implements provider.Observer
+func (n *Notifier) ObserveBatch(_ context.Context, batch provider.Batch) {
+	event, ok := n.eventFor(batch)
+	if !ok {
+		return
+	}
+
+	select {
+	case n.queue <- event:
+	default:
+		log.Printf("Notification queue full, dropping %s event for request %s", event.Type, event.RequestID)
+	}
+}
+
+// eventFor builds the event of a batch and reports whether it passes the
+// filter
+func (n *Notifier) eventFor(batch provider.Batch) (Event, bool) {
+	event := Event{
+		Type:      EventApplied,
+		Time:      batch.ReceivedAt,
+		RequestID: batch.RequestID,
+		Error:     batch.Error,
+	}
+	switch {
+	case batch.Failed():
+		event.Type = EventFailed
+	case batch.DryRun:
+		event.Type = EventDryRun
+	}
+
+	if !n.wantsEvent(event.Type) {
+		return Event{}, false
+	}
+
+	for _, result := range batch.Results {
+		if !n.wantsName(result.DNSName) {
+			continue
+		}
+		change := Change{
+			Name:    result.DNSName,
+			Targets: result.Targets,
+			Status:  result.Status,
+			Reason:  result.Reason,
+		}
+		switch result.Action {
+		case provider.ActionCreate:
+			event.Created = append(event.Created, change)
+		case provider.ActionUpdate:
+			event.Updated = append(event.Updated, change)
+		case provider.ActionDelete:
+			event.Deleted = append(event.Deleted, change)
+		}
+	}
+
+	// Failures that happened before any change was attempted are always
+	// worth reporting, empty successes are not
+	if len(event.Created)+len(event.Updated)+len(event.Deleted) == 0 && (event.Type != EventFailed || len(batch.Results) > 0) {
+		return Event{}, false
+	}
+
+	return event, true
+}
+
+func (n *Notifier) wantsEvent(eventType string) bool {
+	if len(n.filter.Events) == 0 {
+		return true
+	}
+	for _, t := range n.filter.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifier) wantsName(name string) bool {
+	if len(n.filter.Domains) == 0 {
+		return true
+	}
+	name = strings.TrimSuffix(name, ".")
+	for _, domain := range n.filter.Domains {
+		domain = strings.TrimSuffix(strings.TrimPrefix(domain, "."), ".")
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifier) deliver(ctx context.Context, event Event) {
+	for _, sink := range n.sinks {
+		if err := sink.Send(ctx, event); err != nil {
+			log.Printf("Failed to send %s event to %s: %v", event.Type, sink.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+)
+
+func TestEventFor(t *testing.T) {
+	batch := provider.Batch{
+		RequestID: "req-1",
+		Results: []provider.ChangeResult{
+			{Action: provider.ActionCreate, DNSName: "a.example.com", Targets: []string{"1.2.3.4"}, Status: provider.StatusApplied},
+			{Action: provider.ActionUpdate, DNSName: "b.other.com", Targets: []string{"1.2.3.5"}, Status: provider.StatusApplied},
+			{Action: provider.ActionDelete, DNSName: "c.example.com", Status: provider.StatusFailed, Reason: "boom"},
+		},
+		Error: "failed to delete record c.example.com: boom",
+	}
+
+	n := New(Filter{Domains: []string{"example.com"}})
+	event, ok := n.eventFor(batch)
+	if !ok {
+		t.Fatal("Expected an event")
+	}
+	if event.Type != EventFailed {
+		t.Errorf("Expected %s, got %s", EventFailed, event.Type)
+	}
+	if len(event.Created) != 1 || len(event.Updated) != 0 || len(event.Deleted) != 1 {
+		t.Errorf("Expected names outside of the domain filter to be dropped, got %+v", event)
+	}
+
+	n = New(Filter{Events: []string{EventApplied}})
+	if _, ok := n.eventFor(batch); ok {
+		t.Error("Expected failed event to be filtered out")
+	}
+
+	n = New(Filter{Domains: []string{"nothing.test"}})
+	if _, ok := n.eventFor(provider.Batch{Results: batch.Results}); ok {
+		t.Error("Expected event without any matching name to be dropped")
+	}
+}
+
+func TestHTTPSinkRetries(t *testing.T) {
+	var attempts atomic.Int32
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL, 3)
+	sink.backoff = time.Millisecond
+
+	if err := sink.Send(context.Background(), Event{Type: EventApplied, RequestID: "req-1"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts.Load())
+	}
+	if received.RequestID != "req-1" {
+		t.Errorf("Expected event to be delivered, got %+v", received)
+	}
+
+	// Client errors are not retried
+	attempts.Store(0)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		http.Error(w, "bad", http.StatusBadRequest)
+	})
+	if err := sink.Send(context.Background(), Event{Type: EventApplied}); err == nil {
+		t.Error("Expected Send to fail")
+	}
+	if attempts.Load() != 1 {
+		t.Errorf("Expected a single attempt, got %d", attempts.Load())
+	}
+}
+
+func TestDeliverHidesURL(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	closed := server.URL + "/hooks/secret-token"
+	server.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	urls := []string{closed, "http://hooks.test/secret-token\x7f"}
+	for _, u := range urls {
+		New(Filter{}, NewHTTPSink(u, 0), NewSlackSink(u, 0)).deliver(context.Background(), Event{Type: EventApplied})
+	}
+
+	if out := logs.String(); strings.Count(out, "Failed to send") != 4 || strings.Contains(out, "secret-token") {
+		t.Errorf("Expected 4 failures without the webhook URLs, got %s", out)
+	}
+}
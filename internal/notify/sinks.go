@@ -0,0 +1,209 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPSink POSTs events as JSON to a URL, retrying on failure
+type HTTPSink struct {
+	url     string
+	retries int
+	client  *http.Client
+	backoff time.Duration
+}
+
+// NewHTTPSink creates a sink posting events to url. Failed deliveries are
+// retried up to retries times with an exponential backoff.
+func NewHTTPSink(url string, retries int) *HTTPSink {
+	return &HTTPSink{
+		url:     url,
+		retries: retries,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: time.Second,
+	}
+}
+
+// Name implements Sink
+func (s *HTTPSink) Name() string {
+	return "http"
+}
+
+// Send implements Sink
+func (s *HTTPSink) Send(ctx context.Context, event Event) error {
+	return postJSON(ctx, s.client, s.url, event, s.retries, s.backoff)
+}
+
+// SlackSink posts a human-readable summary of events to a Slack or
+// Mattermost incoming webhook
+type SlackSink struct {
+	url     string
+	retries int
+	client  *http.Client
+	backoff time.Duration
+}
+
+// NewSlackSink creates a sink posting to a Slack-compatible incoming webhook
+func NewSlackSink(url string, retries int) *SlackSink {
+	return &SlackSink{
+		url:     url,
+		retries: retries,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		backoff: time.Second,
+	}
+}
+
+// Name implements Sink
+func (s *SlackSink) Name() string {
+	return "slack"
+}
+
+// Send implements Sink
+func (s *SlackSink) Send(ctx context.Context, event Event) error {
+	payload := map[string]string{"text": SlackText(event)}
+	return postJSON(ctx, s.client, s.url, payload, s.retries, s.backoff)
+}
+
+// SlackText formats an event as a Slack/Mattermost message
+func SlackText(event Event) string {
+	var b strings.Builder
+
+	switch event.Type {
+	case EventApplied:
+		b.WriteString(":white_check_mark: DNS changes applied")
+	case EventFailed:
+		b.WriteString(":x: DNS changes failed")
+	case EventDryRun:
+		b.WriteString(":memo: DNS changes received in dry-run mode")
+	default:
+		b.WriteString(event.Type)
+	}
+	if event.RequestID != "" {
+		fmt.Fprintf(&b, " (request `%s`)", event.RequestID)
+	}
+	if event.Error != "" {
+		fmt.Fprintf(&b, "\n> %s", event.Error)
+	}
+
+	writeChanges := func(verb string, changes []Change) {
+		for _, change := range changes {
+			fmt.Fprintf(&b, "\n• %s `%s`", verb, change.Name)
+			if len(change.Targets) > 0 {
+				fmt.Fprintf(&b, " → `%s`", strings.Join(change.Targets, "`, `"))
+			}
+			fmt.Fprintf(&b, " _%s_", change.Status)
+			if change.Reason != "" {
+				fmt.Fprintf(&b, " (%s)", change.Reason)
+			}
+		}
+	}
+	writeChanges("create", event.Created)
+	writeChanges("update", event.Updated)
+	writeChanges("delete", event.Deleted)
+
+	return b.String()
+}
+
+// FileSink appends events as JSON lines to a file
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSink creates a sink appending to path
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Name implements Sink
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+// Send implements Sink
+func (s *FileSink) Send(_ context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", s.path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", s.path, err)
+	}
+
+	return nil
+}
+
+// postJSON posts payload to endpoint, retrying on transport errors, 429 and
+// 5xx responses with an exponential backoff. Errors leave endpoint out, as
+// webhook URLs often embed a token.
+func postJSON(ctx context.Context, client *http.Client, endpoint string, payload any, retries int, backoff time.Duration) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff << (attempt - 1)):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", withoutURL(err))
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to execute request: %w", withoutURL(err))
+			continue
+		}
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+
+		lastErr = fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(respBody))
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			return lastErr
+		}
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", retries+1, lastErr)
+}
+
+// withoutURL strips the URL net/http and net/url add to their errors
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
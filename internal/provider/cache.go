@@ -0,0 +1,85 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// recordCache holds the last inventory fetched from usg-dns-api
+type recordCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	records   []usgdns.Record
+	fetchedAt time.Time
+	valid     bool
+	// err is the error of the last fetch, nil when it succeeded
+	err error
+}
+
+// records returns the inventory of usg-dns-api, from the cache when it is
+// younger than the cache TTL
+func (p *Provider) records(ctx context.Context) ([]usgdns.Record, error) {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	if p.cache.valid && p.cache.ttl > 0 && time.Since(p.cache.fetchedAt) < p.cache.ttl {
+		return p.cache.records, nil
+	}
+
+	return p.fetchLocked(ctx)
+}
+
+// RefreshCache fetches the inventory from usg-dns-api regardless of the
+// cache TTL and returns it
+func (p *Provider) RefreshCache(ctx context.Context) ([]usgdns.Record, error) {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	return p.fetchLocked(ctx)
+}
+
+// CachedAt returns when the inventory was last fetched, or the zero time
+func (p *Provider) CachedAt() time.Time {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	return p.cache.fetchedAt
+}
+
+// FetchError returns the error of the last inventory fetch from
+// usg-dns-api, or nil when it succeeded. Records served from the cache
+// meanwhile do not clear it.
+func (p *Provider) FetchError() error {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	return p.cache.err
+}
+
+// invalidateCache forces the next lookup to reach usg-dns-api, after we
+// changed something there
+func (p *Provider) invalidateCache() {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	p.cache.valid = false
+}
+
+func (p *Provider) fetchLocked(ctx context.Context) ([]usgdns.Record, error) {
+	records, err := p.client.GetRecords(ctx)
+	if err != nil {
+		p.cache.err = fmt.Errorf("failed to get records: %w", err)
+		return nil, p.cache.err
+	}
+	p.cache.err = nil
+
+	p.cache.records = records
+	p.cache.fetchedAt = time.Now()
+	p.cache.valid = true
+
+	return records, nil
+}
@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// historySize is the number of batches kept in memory
+const historySize = 50
+
+// Batch records a set of changes received by the provider and its outcome
+type Batch struct {
+	RequestID  string           `json:"requestId,omitempty"`
+	Caller     string           `json:"caller,omitempty"`
+	ReceivedAt time.Time        `json:"receivedAt"`
+	DryRun     bool             `json:"dryRun"`
+	Changes    *webhook.Changes `json:"changes"`
+	Results    []ChangeResult   `json:"results"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx carrying the identity of the caller
+// sending changes, recorded in the batch
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// callerFromContext returns the identity of the caller, if any
+func callerFromContext(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// Failed reports whether the batch could not be fully applied
+func (b *Batch) Failed() bool {
+	return b.Error != ""
+}
+
+// recordChange is the last change we made to a given record
+type recordChange struct {
+	Action    Action
+	ChangedAt time.Time
+	RequestID string
+}
+
+// history keeps the latest batches and the last change made to each name
+type history struct {
+	mu      sync.RWMutex
+	batches []Batch
+	changes map[string]recordChange
+}
+
+func newHistory() *history {
+	return &history{
+		changes: make(map[string]recordChange),
+	}
+}
+
+func (h *history) add(batch Batch) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.batches = append(h.batches, batch)
+	if len(h.batches) > historySize {
+		h.batches = h.batches[len(h.batches)-historySize:]
+	}
+
+	for _, result := range batch.Results {
+		if result.Status != StatusApplied {
+			continue
+		}
+		h.changes[result.DNSName] = recordChange{
+			Action:    result.Action,
+			ChangedAt: batch.ReceivedAt,
+			RequestID: batch.RequestID,
+		}
+	}
+}
+
+// History returns the latest batches, newest first
+func (p *Provider) History() []Batch {
+	p.history.mu.RLock()
+	defer p.history.mu.RUnlock()
+
+	batches := make([]Batch, len(p.history.batches))
+	for i, batch := range p.history.batches {
+		batches[len(batches)-1-i] = batch
+	}
+	return batches
+}
+
+// LastBatch returns the last batch received, or nil
+func (p *Provider) LastBatch() *Batch {
+	p.history.mu.RLock()
+	defer p.history.mu.RUnlock()
+
+	if len(p.history.batches) == 0 {
+		return nil
+	}
+	batch := p.history.batches[len(p.history.batches)-1]
+	return &batch
+}
@@ -0,0 +1,138 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// Outcomes of a planned operation
+const (
+	// OutcomeApply means the operation would be sent to usg-dns-api
+	OutcomeApply = "apply"
+	// OutcomeNoop means there is nothing to do, such as deleting a record
+	// which does not exist
+	OutcomeNoop = "noop"
+	// OutcomeFail means the operation would fail and stop the batch
+	OutcomeFail = "fail"
+)
+
+// PlannedOperation is what ApplyChanges would do for a single change
+type PlannedOperation struct {
+	Action  Action `json:"action"`
+	DNSName string `json:"dnsName"`
+	Outcome string `json:"outcome"`
+
+	// Method and Path are the request sent to usg-dns-api
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+
+	// RecordID and CurrentTarget describe the existing record
+	RecordID      string `json:"recordId,omitempty"`
+	CurrentTarget string `json:"currentTarget,omitempty"`
+
+	// Target is the target sent, DroppedTargets are the ones ignored as
+	// usg-dns-api only holds a single target per record
+	Target         string   `json:"target,omitempty"`
+	DroppedTargets []string `json:"droppedTargets,omitempty"`
+
+	Reason string `json:"reason,omitempty"`
+}
+
+// Plan resolves changes against the live records of usg-dns-api and returns
+// the operations ApplyChanges would perform, in the same order, without
+// changing anything. Operations after the first failing one would not be
+// attempted. A batch exceeding the deletion limit fails as a whole with
+// ErrTooManyDeletions.
+func (p *Provider) Plan(ctx context.Context, changes *webhook.Changes) ([]PlannedOperation, error) {
+	if maxDeletions := p.MaxDeletions(); maxDeletions > 0 && len(changes.Delete) > maxDeletions {
+		return nil, fmt.Errorf("%w: batch deletes %d records, more than the limit of %d", ErrTooManyDeletions, len(changes.Delete), maxDeletions)
+	}
+
+	records, err := p.RefreshCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := p.operations(changes)
+	planned := make([]PlannedOperation, 0, len(ops))
+	for _, op := range ops {
+		planned = append(planned, planOperation(op, records))
+	}
+	return planned, nil
+}
+
+func planOperation(op operation, records []usgdns.Record) PlannedOperation {
+	planned := PlannedOperation{
+		Action:  op.action,
+		DNSName: op.endpoint.DNSName,
+	}
+
+	// Lookups resolve records as ApplyChanges does
+	lookup := op.endpoint.DNSName
+	if op.previous != nil {
+		lookup = op.previous.DNSName
+	}
+	var record *usgdns.Record
+	switch {
+	case op.action == ActionDelete:
+		record = findRecord(records, op.endpoint.DNSName, op.endpoint.Targets)
+	case op.previous != nil:
+		record = findUpdatedRecord(records, op.previous)
+	default:
+		record = findRecord(records, lookup, nil)
+	}
+	var existing usgdns.Record
+	found := false
+	if record != nil {
+		existing, found = *record, true
+	}
+	if found {
+		planned.RecordID = existing.ID
+		planned.CurrentTarget = existing.Target
+	}
+
+	if op.action != ActionDelete {
+		if len(op.endpoint.Targets) == 0 {
+			planned.Outcome = OutcomeFail
+			planned.Reason = fmt.Sprintf("%v: no targets specified", ErrInvalidChange)
+			return planned
+		}
+		planned.Target = op.endpoint.Targets[0]
+		planned.DroppedTargets = op.endpoint.Targets[1:]
+	}
+
+	switch op.action {
+	case ActionCreate:
+		planned.Outcome = OutcomeApply
+		planned.Method = http.MethodPost
+		planned.Path = "/records"
+		if found {
+			planned.Reason = fmt.Sprintf("a record named %s already exists", lookup)
+		}
+	case ActionUpdate:
+		if !found {
+			planned.Outcome = OutcomeFail
+			planned.Reason = fmt.Sprintf("%v: %s", ErrRecordNotFound, lookup)
+			return planned
+		}
+		planned.Outcome = OutcomeApply
+		planned.Method = http.MethodPut
+		planned.Path = "/records/" + url.PathEscape(existing.ID)
+	case ActionDelete:
+		if !found {
+			planned.Outcome = OutcomeNoop
+			planned.Reason = "record not found, considered already deleted"
+			return planned
+		}
+		planned.Outcome = OutcomeApply
+		planned.Method = http.MethodDelete
+		planned.Path = "/records/" + url.PathEscape(existing.ID)
+	}
+
+	return planned
+}
@@ -1,44 +1,254 @@
 package provider
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/requestid"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/tracing"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
 )
 
 // Provider implements the external-dns webhook provider for usg-dns-api
 type Provider struct {
-	client       *usgdns.Client
+	client *usgdns.Client
+
+	// mu guards the settings which can be changed at runtime
+	mu           sync.RWMutex
 	domainFilter []string
 	dryRun       bool
+	paused       bool
+	maxDeletions int
+
+	cache       *recordCache
+	history     *history
+	observers   []Observer
+	snapshotter Snapshotter
+}
+
+// Snapshotter saves the inventory of usg-dns-api before a batch of changes
+// is applied to it
+type Snapshotter interface {
+	Snapshot(ctx context.Context, requestID string, records []usgdns.Record) error
+}
+
+// Observer is notified after each batch of changes, whether it succeeded
+// or not. Observers are called synchronously and must not block.
+type Observer interface {
+	ObserveBatch(ctx context.Context, batch Batch)
+}
+
+// Option configures optional provider behavior
+type Option func(*Provider)
+
+// WithCacheTTL serves the inventory of usg-dns-api from memory for ttl
+// after fetching it. Any change we apply invalidates the cache.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(p *Provider) {
+		p.cache.ttl = ttl
+	}
+}
+
+// WithObserver registers an observer of the batches of changes
+func WithObserver(observer Observer) Option {
+	return func(p *Provider) {
+		p.observers = append(p.observers, observer)
+	}
+}
+
+// WithSnapshotter saves the full inventory of usg-dns-api before applying
+// each batch of changes. A batch is not applied when its snapshot fails.
+func WithSnapshotter(snapshotter Snapshotter) Option {
+	return func(p *Provider) {
+		p.snapshotter = snapshotter
+	}
+}
+
+// WithMaxDeletions refuses batches deleting more than n records, as a
+// safeguard against mass deletions. Zero disables the limit.
+func WithMaxDeletions(n int) Option {
+	return func(p *Provider) {
+		p.maxDeletions = n
+	}
+}
+
+// AddObserver registers an observer after the provider was created, for
+// observers that need the provider themselves
+func (p *Provider) AddObserver(observer Observer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.observers = append(p.observers, observer)
 }
 
 // NewProvider creates a new provider instance
-func NewProvider(client *usgdns.Client, domainFilter []string, dryRun bool) *Provider {
-	return &Provider{
+func NewProvider(client *usgdns.Client, domainFilter []string, dryRun bool, opts ...Option) *Provider {
+	p := &Provider{
 		client:       client,
 		domainFilter: domainFilter,
 		dryRun:       dryRun,
+		cache:        &recordCache{},
+		history:      newHistory(),
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	return p
 }
 
 // GetDomainFilter returns the domain filter
 func (p *Provider) GetDomainFilter() webhook.DomainFilter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	return webhook.DomainFilter{
 		Filters: p.domainFilter,
 	}
 }
 
+// Settings are the provider settings which can be changed at runtime
+type Settings struct {
+	DomainFilter []string
+	DryRun       bool
+	MaxDeletions int
+}
+
+// Reconfigure replaces the runtime settings at once. Batches of changes
+// being applied keep the previous dry-run mode and deletion limit.
+func (p *Provider) Reconfigure(settings Settings) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.domainFilter = settings.DomainFilter
+	p.dryRun = settings.DryRun
+	p.maxDeletions = settings.MaxDeletions
+}
+
+// MaxDeletions returns the maximum number of deletions in a batch, zero
+// meaning no limit
+func (p *Provider) MaxDeletions() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.maxDeletions
+}
+
+// DryRun reports whether changes are only logged
+func (p *Provider) DryRun() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.dryRun
+}
+
+// SetDryRun enables or disables dry-run mode
+func (p *Provider) SetDryRun(dryRun bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.dryRun = dryRun
+}
+
+// Paused reports whether applying changes is suspended
+func (p *Provider) Paused() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.paused
+}
+
+// SetPaused suspends or resumes applying changes. While paused,
+// ApplyChanges fails with ErrPaused so that external-dns retries later.
+func (p *Provider) SetPaused(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paused = paused
+}
+
+// Manages reports whether name is within the domain filter
+func (p *Provider) Manages(name string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.domainFilter) == 0 {
+		return true
+	}
+
+	name = strings.TrimSuffix(name, ".")
+	for _, filter := range p.domainFilter {
+		filter = strings.TrimSuffix(strings.TrimPrefix(filter, "."), ".")
+		if name == filter || strings.HasSuffix(name, "."+filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// ManagedRecord is a record of usg-dns-api within the domain filter, along
+// with the last change we made to it since startup
+type ManagedRecord struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	Target        string     `json:"target"`
+	LastAction    Action     `json:"lastAction,omitempty"`
+	LastChangedAt *time.Time `json:"lastChangedAt,omitempty"`
+	LastRequestID string     `json:"lastRequestId,omitempty"`
+}
+
+// ManagedRecords returns the records within the domain filter
+func (p *Provider) ManagedRecords(ctx context.Context) ([]ManagedRecord, error) {
+	records, err := p.records(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.history.mu.RLock()
+	defer p.history.mu.RUnlock()
+
+	managed := make([]ManagedRecord, 0, len(records))
+	for _, record := range records {
+		if !p.Manages(record.Name) {
+			continue
+		}
+		m := ManagedRecord{
+			ID:     record.ID,
+			Name:   record.Name,
+			Target: record.Target,
+		}
+		if change, ok := p.history.changes[record.Name]; ok {
+			changedAt := change.ChangedAt
+			m.LastAction = change.Action
+			m.LastChangedAt = &changedAt
+			m.LastRequestID = change.RequestID
+		}
+		managed = append(managed, m)
+	}
+
+	return managed, nil
+}
+
 // GetRecords returns all DNS records
-func (p *Provider) GetRecords() ([]*webhook.Endpoint, error) {
-	records, err := p.client.GetRecords()
+func (p *Provider) GetRecords(ctx context.Context) ([]*webhook.Endpoint, error) {
+	records, err := p.records(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get records: %w", err)
+		return nil, err
 	}
 
+	return Endpoints(records), nil
+}
+
+// Endpoints converts records of usg-dns-api to external-dns endpoints
+func Endpoints(records []usgdns.Record) []*webhook.Endpoint {
 	endpoints := make([]*webhook.Endpoint, 0, len(records))
 	for _, record := range records {
 		// Only handle A records for now
@@ -49,26 +259,196 @@ func (p *Provider) GetRecords() ([]*webhook.Endpoint, error) {
 			RecordTTL:  300, // Default TTL
 		})
 	}
-
-	return endpoints, nil
+	return endpoints
 }
 
-// ApplyChanges applies the given changes
-func (p *Provider) ApplyChanges(changes *webhook.Changes) error {
-	if p.dryRun {
+// ApplyChanges applies the given changes and returns the outcome of each
+// of them. Changes are applied in order (creates, updates then deletes) and
+// processing stops at the first failure, the remaining changes being
+// reported as skipped.
+func (p *Provider) ApplyChanges(ctx context.Context, changes *webhook.Changes) (results []ChangeResult, err error) {
+	p.mu.RLock()
+	dryRun, paused, maxDeletions := p.dryRun, p.paused, p.maxDeletions
+	p.mu.RUnlock()
+
+	ctx, span := tracing.Start(ctx, "provider.ApplyChanges",
+		attribute.Int("changes.create", len(changes.Create)),
+		attribute.Int("changes.update", len(changes.UpdateNew)),
+		attribute.Int("changes.delete", len(changes.Delete)),
+		attribute.Bool("dry_run", dryRun),
+	)
+
+	batch := Batch{
+		RequestID:  requestid.FromContext(ctx),
+		Caller:     callerFromContext(ctx),
+		ReceivedAt: time.Now(),
+		DryRun:     dryRun,
+		Changes:    changes,
+	}
+	defer func() {
+		batch.Results = results
+		if err != nil {
+			batch.Error = err.Error()
+		}
+		p.history.add(batch)
+
+		p.mu.RLock()
+		observers := p.observers
+		p.mu.RUnlock()
+		for _, observer := range observers {
+			observer.ObserveBatch(ctx, batch)
+		}
+
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	ops := p.operations(changes)
+	results = make([]ChangeResult, len(ops))
+	for i, op := range ops {
+		results[i] = ChangeResult{
+			Action:  op.action,
+			DNSName: op.endpoint.DNSName,
+			Targets: op.endpoint.Targets,
+			Status:  StatusSkipped,
+		}
+		if op.previous != nil {
+			results[i].OldTargets = op.previous.Targets
+		}
+	}
+
+	if paused {
+		for i := range results {
+			results[i].Reason = "paused"
+		}
+		return results, ErrPaused
+	}
+
+	// The limit also applies in dry-run mode, to show what would happen
+	if maxDeletions > 0 && len(changes.Delete) > maxDeletions {
+		reason := fmt.Sprintf("batch deletes %d records, more than the limit of %d", len(changes.Delete), maxDeletions)
+		for i := range results {
+			results[i].Reason = reason
+		}
+		return results, fmt.Errorf("%w: %s", ErrTooManyDeletions, reason)
+	}
+
+	if dryRun {
 		log.Println("[DRY RUN] Would apply changes:")
 		log.Printf("[DRY RUN] Create: %d records", len(changes.Create))
 		log.Printf("[DRY RUN] Update: %d records", len(changes.UpdateNew))
 		log.Printf("[DRY RUN] Delete: %d records", len(changes.Delete))
-		return nil
+		for i := range results {
+			results[i].Reason = "dry run"
+		}
+		return results, nil
+	}
+
+	if p.snapshotter != nil && len(ops) > 0 {
+		if err := p.snapshot(ctx, batch.RequestID); err != nil {
+			for i := range results {
+				results[i].Reason = "no snapshot could be taken"
+			}
+			return results, fmt.Errorf("failed to snapshot records before applying changes: %w", err)
+		}
+	}
+
+	// Whatever happens, our view of usg-dns-api is outdated once we start
+	defer p.invalidateCache()
+
+	for i, op := range ops {
+		applied, err := op.apply(ctx)
+
+		// A change made on the usg-dns-api instance of the record is
+		// applied, even when other instances missed it
+		var writeErr *usgdns.WriteError
+		if errors.As(err, &writeErr) {
+			results[i].Reason = writeErr.Error()
+			results[i].Backends = backendResults(writeErr)
+			log.Printf("Failed to %s record %s on every usg-dns-api instance: %v", op.action, op.endpoint.DNSName, err)
+			err = nil
+		}
+
+		if err != nil {
+			results[i].Status = StatusFailed
+			results[i].Reason = err.Error()
+			for j := i + 1; j < len(results); j++ {
+				results[j].Reason = "not attempted after an earlier failure"
+			}
+			return results, fmt.Errorf("failed to %s record %s: %w", op.action, op.endpoint.DNSName, err)
+		}
+
+		if !applied {
+			results[i].Reason = "record not found, considered already deleted"
+			continue
+		}
+
+		results[i].Status = StatusApplied
+		switch op.action {
+		case ActionCreate:
+			log.Printf("Created record: %s -> %v", op.endpoint.DNSName, op.endpoint.Targets)
+		case ActionUpdate:
+			log.Printf("Updated record: %s -> %v", op.endpoint.DNSName, op.endpoint.Targets)
+		case ActionDelete:
+			log.Printf("Deleted record: %s", op.endpoint.DNSName)
+		}
 	}
 
+	return results, nil
+}
+
+// backendResults converts the outcome of a write on each usg-dns-api
+// instance
+func backendResults(err *usgdns.WriteError) []BackendResult {
+	results := make([]BackendResult, len(err.Results))
+	for i, result := range err.Results {
+		results[i] = BackendResult{URL: result.URL, Status: StatusApplied}
+		if result.Err != nil {
+			results[i].Status = StatusFailed
+			results[i].Reason = result.Err.Error()
+		}
+	}
+	return results
+}
+
+// snapshot saves the current inventory of usg-dns-api
+func (p *Provider) snapshot(ctx context.Context, requestID string) (err error) {
+	ctx, span := tracing.Start(ctx, "provider.snapshot")
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	records, err := p.RefreshCache(ctx)
+	if err != nil {
+		return err
+	}
+	return p.snapshotter.Snapshot(ctx, requestID, records)
+}
+
+// operation is a single change to apply. previous is the endpoint as
+// external-dns saw it before an update or a delete. apply reports whether
+// something was actually changed on usg-dns-api.
+type operation struct {
+	action   Action
+	endpoint *webhook.Endpoint
+	previous *webhook.Endpoint
+	apply    func(ctx context.Context) (bool, error)
+}
+
+// operations flattens the changes into the ordered list of operations
+func (p *Provider) operations(changes *webhook.Changes) []operation {
+	ops := make([]operation, 0, len(changes.Create)+len(changes.UpdateNew)+len(changes.Delete))
+
 	// Handle creates
 	for _, endpoint := range changes.Create {
-		if err := p.createRecord(endpoint); err != nil {
-			return fmt.Errorf("failed to create record %s: %w", endpoint.DNSName, err)
-		}
-		log.Printf("Created record: %s -> %v", endpoint.DNSName, endpoint.Targets)
+		ops = append(ops, operation{
+			action:   ActionCreate,
+			endpoint: endpoint,
+			apply: func(ctx context.Context) (bool, error) {
+				return true, p.createRecord(ctx, endpoint)
+			},
+		})
 	}
 
 	// Handle updates
@@ -77,21 +457,29 @@ func (p *Provider) ApplyChanges(changes *webhook.Changes) error {
 			break
 		}
 		newEndpoint := changes.UpdateNew[i]
-		if err := p.updateRecord(oldEndpoint, newEndpoint); err != nil {
-			return fmt.Errorf("failed to update record %s: %w", newEndpoint.DNSName, err)
-		}
-		log.Printf("Updated record: %s -> %v", newEndpoint.DNSName, newEndpoint.Targets)
+		ops = append(ops, operation{
+			action:   ActionUpdate,
+			endpoint: newEndpoint,
+			previous: oldEndpoint,
+			apply: func(ctx context.Context) (bool, error) {
+				return true, p.updateRecord(ctx, oldEndpoint, newEndpoint)
+			},
+		})
 	}
 
 	// Handle deletes
 	for _, endpoint := range changes.Delete {
-		if err := p.deleteRecord(endpoint); err != nil {
-			return fmt.Errorf("failed to delete record %s: %w", endpoint.DNSName, err)
-		}
-		log.Printf("Deleted record: %s", endpoint.DNSName)
+		ops = append(ops, operation{
+			action:   ActionDelete,
+			endpoint: endpoint,
+			previous: endpoint,
+			apply: func(ctx context.Context) (bool, error) {
+				return p.deleteRecord(ctx, endpoint)
+			},
+		})
 	}
 
-	return nil
+	return ops
 }
 
 // AdjustEndpoints adjusts endpoints (optional, can return as-is)
@@ -118,6 +506,9 @@ func (p *Provider) normalizeDNSName(dnsName string) string {
 	// Remove trailing dot if present
 	dnsName = strings.TrimSuffix(dnsName, ".")
 
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	// If domain filters are defined, try to make the name relative
 	for _, filter := range p.domainFilter {
 		filter = strings.TrimPrefix(filter, ".")
@@ -130,66 +521,110 @@ func (p *Provider) normalizeDNSName(dnsName string) string {
 	return dnsName
 }
 
-func (p *Provider) createRecord(endpoint *webhook.Endpoint) error {
+func (p *Provider) createRecord(ctx context.Context, endpoint *webhook.Endpoint) (err error) {
+	ctx, span := tracing.Start(ctx, "provider.create", endpointAttributes(endpoint)...)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	if len(endpoint.Targets) == 0 {
-		return fmt.Errorf("no targets specified")
+		return fmt.Errorf("%w: no targets specified", ErrInvalidChange)
 	}
 
 	// Only support A records with a single target
 	target := endpoint.Targets[0]
 
-	_, err := p.client.CreateRecord(endpoint.DNSName, target)
+	_, err = p.client.CreateRecord(ctx, endpoint.DNSName, target)
 	return err
 }
 
-func (p *Provider) updateRecord(oldEndpoint, newEndpoint *webhook.Endpoint) error {
-	// First, find the record by name
-	records, err := p.client.GetRecords()
+func (p *Provider) updateRecord(ctx context.Context, oldEndpoint, newEndpoint *webhook.Endpoint) (err error) {
+	ctx, span := tracing.Start(ctx, "provider.update", endpointAttributes(newEndpoint)...)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	// First, find the record by name, preferring the one holding the old target
+	records, err := p.records(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get records: %w", err)
+		return err
 	}
 
-	var recordID string
-	for _, record := range records {
-		if record.Name == oldEndpoint.DNSName {
-			recordID = record.ID
-			break
-		}
-	}
+	found := findUpdatedRecord(records, oldEndpoint)
 
-	if recordID == "" {
-		return fmt.Errorf("record not found: %s", oldEndpoint.DNSName)
+	if found == nil {
+		return fmt.Errorf("%w: %s", ErrRecordNotFound, oldEndpoint.DNSName)
 	}
 
 	if len(newEndpoint.Targets) == 0 {
-		return fmt.Errorf("no targets specified")
+		return fmt.Errorf("%w: no targets specified", ErrInvalidChange)
 	}
 
 	target := newEndpoint.Targets[0]
-	_, err = p.client.UpdateRecord(recordID, newEndpoint.DNSName, target)
+	span.SetAttributes(attribute.String("record.id", found.ID))
+	_, err = p.client.UpdateRecord(ctx, *found, newEndpoint.DNSName, target)
 	return err
 }
 
-func (p *Provider) deleteRecord(endpoint *webhook.Endpoint) error {
+// deleteRecord deletes the record named after endpoint and reports whether
+// it existed
+func (p *Provider) deleteRecord(ctx context.Context, endpoint *webhook.Endpoint) (deleted bool, err error) {
+	ctx, span := tracing.Start(ctx, "provider.delete", endpointAttributes(endpoint)...)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	// Find the record by name
-	records, err := p.client.GetRecords()
+	records, err := p.records(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get records: %w", err)
+		return false, err
 	}
 
-	var recordID string
-	for _, record := range records {
-		if record.Name == endpoint.DNSName {
-			recordID = record.ID
-			break
+	found := findRecord(records, endpoint.DNSName, endpoint.Targets)
+	if found == nil {
+		// Record not found, consider it already deleted
+		log.Printf("Record %s not found, considering it already deleted", endpoint.DNSName)
+		return false, nil
+	}
+
+	span.SetAttributes(attribute.String("record.id", found.ID))
+	if err := p.client.DeleteRecord(ctx, *found); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// findRecord returns the first record named name whose target is one of
+// targets, or the first record named name when targets is empty, so that
+// deleting one of the records of a name leaves the others alone
+func findRecord(records []usgdns.Record, name string, targets []string) *usgdns.Record {
+	for i, record := range records {
+		if record.Name == name && (len(targets) == 0 || slices.Contains(targets, record.Target)) {
+			return &records[i]
 		}
 	}
+	return nil
+}
 
-	if recordID == "" {
-		// Record not found, consider it already deleted
-		log.Printf("Record %s not found, considering it already deleted", endpoint.DNSName)
-		return nil
+// findUpdatedRecord returns the record an update of oldEndpoint applies to:
+// the one of its name holding one of its targets, or else the first of its
+// name, as external-dns may not know the current target
+func findUpdatedRecord(records []usgdns.Record, oldEndpoint *webhook.Endpoint) *usgdns.Record {
+	if len(oldEndpoint.Targets) > 0 {
+		if found := findRecord(records, oldEndpoint.DNSName, oldEndpoint.Targets); found != nil {
+			return found
+		}
 	}
+	return findRecord(records, oldEndpoint.DNSName, nil)
+}
 
-	return p.client.DeleteRecord(recordID)
+// endpointAttributes returns the span attributes describing an endpoint
+func endpointAttributes(endpoint *webhook.Endpoint) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("dns.name", endpoint.DNSName),
+		attribute.StringSlice("dns.targets", endpoint.Targets),
+	}
 }
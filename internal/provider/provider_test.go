@@ -1,8 +1,16 @@
 package provider
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
 )
@@ -110,3 +118,230 @@ func TestAdjustEndpoints(t *testing.T) {
 		}
 	}
 }
+
+func TestManages(t *testing.T) {
+	client := usgdns.NewClient("http://test.local", "test-token")
+	provider := NewProvider(client, []string{"example.com", ".test.local."}, false)
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"example.com", true},
+		{"www.example.com.", true},
+		{"a.b.test.local", true},
+		{"notexample.com", false},
+		{"example.org", false},
+	}
+
+	for _, tt := range tests {
+		if result := provider.Manages(tt.name); result != tt.expected {
+			t.Errorf("Manages(%q) = %v, expected %v", tt.name, result, tt.expected)
+		}
+	}
+}
+
+func TestApplyChangesPausedAndDryRun(t *testing.T) {
+	// No request may reach this client
+	client := usgdns.NewClient("http://127.0.0.1:1", "test-token")
+	provider := NewProvider(client, nil, false)
+
+	changes := &webhook.Changes{
+		Create: []*webhook.Endpoint{{DNSName: "test.example.com", Targets: []string{"1.2.3.4"}}},
+	}
+
+	provider.SetPaused(true)
+	results, err := provider.ApplyChanges(context.Background(), changes)
+	if !errors.Is(err, ErrPaused) {
+		t.Fatalf("Expected ErrPaused, got %v", err)
+	}
+	if len(results) != 1 || results[0].Status != StatusSkipped {
+		t.Errorf("Expected the change to be skipped, got %+v", results)
+	}
+
+	provider.SetPaused(false)
+	provider.SetDryRun(true)
+	results, err = provider.ApplyChanges(context.Background(), changes)
+	if err != nil {
+		t.Fatalf("Expected dry run to succeed, got %v", err)
+	}
+	if len(results) != 1 || results[0].Status != StatusSkipped || results[0].Reason != "dry run" {
+		t.Errorf("Expected the change to be skipped in dry run, got %+v", results)
+	}
+
+	history := provider.History()
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 batches in history, got %d", len(history))
+	}
+	if !history[1].Failed() || history[0].Failed() || !history[0].DryRun {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+}
+
+func TestApplyChangesMaxDeletions(t *testing.T) {
+	// No request may reach this client
+	client := usgdns.NewClient("http://127.0.0.1:1", "test-token")
+	provider := NewProvider(client, nil, true, WithMaxDeletions(1))
+
+	changes := &webhook.Changes{
+		Delete: []*webhook.Endpoint{
+			{DNSName: "a.example.com", Targets: []string{"1.2.3.4"}},
+			{DNSName: "b.example.com", Targets: []string{"1.2.3.5"}},
+		},
+	}
+
+	// Refused even in dry-run mode
+	results, err := provider.ApplyChanges(context.Background(), changes)
+	if !errors.Is(err, ErrTooManyDeletions) {
+		t.Fatalf("Expected ErrTooManyDeletions, got %v", err)
+	}
+	if len(results) != 2 || results[0].Status != StatusSkipped || results[0].Reason == "" {
+		t.Errorf("Expected the changes to be skipped with a reason, got %+v", results)
+	}
+	if _, err := provider.Plan(context.Background(), changes); !errors.Is(err, ErrTooManyDeletions) {
+		t.Errorf("Expected Plan to fail with ErrTooManyDeletions, got %v", err)
+	}
+
+	changes.Delete = changes.Delete[:1]
+	if _, err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Errorf("Expected a single deletion to be allowed, got %v", err)
+	}
+}
+
+func TestApplyChangesSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`[{"id":"1","name":"a.example.com","target":"1.2.3.4"},{"id":"2","name":"b.example.com","target":"1.2.3.5"}]`))
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Write([]byte(`{"id":"3","name":"c.example.com","target":"1.2.3.6"}`))
+		}
+	}))
+	defer gateway.Close()
+
+	provider := NewProvider(usgdns.NewClient(gateway.URL, "test-token"), nil, false)
+	changes := &webhook.Changes{
+		Create:    []*webhook.Endpoint{{DNSName: "c.example.com", Targets: []string{"1.2.3.6"}}},
+		UpdateOld: []*webhook.Endpoint{{DNSName: "a.example.com", Targets: []string{"1.2.3.4"}}},
+		UpdateNew: []*webhook.Endpoint{{DNSName: "a.example.com", Targets: []string{"1.2.3.7"}}},
+		Delete:    []*webhook.Endpoint{{DNSName: "b.example.com", Targets: []string{"1.2.3.5"}}},
+	}
+	if _, err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("ApplyChanges failed: %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	batch, ok := spans["provider.ApplyChanges"]
+	if !ok {
+		t.Fatalf("Expected a provider.ApplyChanges span, got %v", spans)
+	}
+	for _, name := range []string{"provider.create", "provider.update", "provider.delete"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected a %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != batch.SpanContext().SpanID() {
+			t.Errorf("Expected %s to be a child of provider.ApplyChanges", name)
+		}
+	}
+}
+
+func TestDeleteMatchesTarget(t *testing.T) {
+	var deleted []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`[{"id":"1","name":"a.example.com","target":"192.168.1.1"},{"id":"2","name":"a.example.com","target":"10.0.0.2"}]`))
+		case http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer gateway.Close()
+
+	provider := NewProvider(usgdns.NewClient(gateway.URL, "test-token"), nil, false)
+	changes := &webhook.Changes{
+		Delete: []*webhook.Endpoint{{DNSName: "a.example.com", Targets: []string{"10.0.0.2"}}},
+	}
+
+	planned, err := provider.Plan(context.Background(), changes)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(planned) != 1 || planned[0].RecordID != "2" {
+		t.Errorf("Expected the record with the target to be planned, got %+v", planned)
+	}
+
+	if _, err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("ApplyChanges failed: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/records/2" {
+		t.Errorf("Expected only the record with the target to be deleted, got %v", deleted)
+	}
+
+	// Without a target, the first record of the name is deleted
+	deleted = nil
+	changes.Delete[0].Targets = nil
+	if _, err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("ApplyChanges failed: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "/records/1" {
+		t.Errorf("Expected the first record of the name to be deleted, got %v", deleted)
+	}
+}
+
+func TestUpdateMatchesTarget(t *testing.T) {
+	var updated []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`[{"id":"1","name":"a.example.com","target":"192.168.1.1"},{"id":"2","name":"a.example.com","target":"10.0.0.2"}]`))
+		case http.MethodPut:
+			updated = append(updated, r.URL.Path)
+			w.Write([]byte(`{"id":"2","name":"a.example.com","target":"10.0.0.3"}`))
+		}
+	}))
+	defer gateway.Close()
+
+	provider := NewProvider(usgdns.NewClient(gateway.URL, "test-token"), nil, false)
+	changes := &webhook.Changes{
+		UpdateOld: []*webhook.Endpoint{{DNSName: "a.example.com", Targets: []string{"10.0.0.2"}}},
+		UpdateNew: []*webhook.Endpoint{{DNSName: "a.example.com", Targets: []string{"10.0.0.3"}}},
+	}
+
+	planned, err := provider.Plan(context.Background(), changes)
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(planned) != 1 || planned[0].RecordID != "2" {
+		t.Errorf("Expected the record with the old target to be planned, got %+v", planned)
+	}
+
+	if _, err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("ApplyChanges failed: %v", err)
+	}
+	if len(updated) != 1 || updated[0] != "/records/2" {
+		t.Errorf("Expected only the record with the old target to be updated, got %v", updated)
+	}
+
+	// An unknown old target falls back to the first record of the name
+	updated = nil
+	changes.UpdateOld[0].Targets = []string{"172.16.0.1"}
+	if _, err := provider.ApplyChanges(context.Background(), changes); err != nil {
+		t.Fatalf("ApplyChanges failed: %v", err)
+	}
+	if len(updated) != 1 || updated[0] != "/records/1" {
+		t.Errorf("Expected the first record of the name to be updated, got %v", updated)
+	}
+}
@@ -0,0 +1,60 @@
+package provider
+
+import "errors"
+
+var (
+	// ErrInvalidChange is returned when a change cannot be applied as is
+	ErrInvalidChange = errors.New("invalid change")
+
+	// ErrRecordNotFound is returned when a record to update does not exist
+	ErrRecordNotFound = errors.New("record not found")
+
+	// ErrPaused is returned when applying changes has been suspended
+	ErrPaused = errors.New("applying changes is paused")
+
+	// ErrTooManyDeletions is returned when a batch deletes more records
+	// than allowed
+	ErrTooManyDeletions = errors.New("too many deletions")
+)
+
+// Action is the kind of operation a change performs
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// ChangeStatus is the outcome of a single change
+type ChangeStatus string
+
+const (
+	// StatusApplied means the change was made on usg-dns-api
+	StatusApplied ChangeStatus = "applied"
+	// StatusSkipped means the change was not attempted or had nothing to do
+	StatusSkipped ChangeStatus = "skipped"
+	// StatusFailed means usg-dns-api rejected the change or could not be reached
+	StatusFailed ChangeStatus = "failed"
+)
+
+// ChangeResult describes the outcome of a single change. OldTargets are
+// the targets external-dns expected before an update or a delete.
+// Backends details the outcome on each usg-dns-api instance when a change
+// written to all of them failed on some.
+type ChangeResult struct {
+	Action     Action          `json:"action"`
+	DNSName    string          `json:"dnsName"`
+	OldTargets []string        `json:"oldTargets,omitempty"`
+	Targets    []string        `json:"targets,omitempty"`
+	Status     ChangeStatus    `json:"status"`
+	Reason     string          `json:"reason,omitempty"`
+	Backends   []BackendResult `json:"backends,omitempty"`
+}
+
+// BackendResult is the outcome of a change on one usg-dns-api instance
+type BackendResult struct {
+	URL    string       `json:"url"`
+	Status ChangeStatus `json:"status"`
+	Reason string       `json:"reason,omitempty"`
+}
@@ -0,0 +1,156 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/diff"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/filewatch"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/format"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// Caller identifies the changes made by the reconciler in the audit log
+const Caller = "reconcile"
+
+// ErrEmptyState is returned when the desired state is empty while records
+// exist, which more likely means a truncated file than a wish to delete
+// every record
+var ErrEmptyState = errors.New("desired state is empty")
+
+// Reconciler brings the records within the domain filter of a provider to
+// the state described by a file: records missing from the file are deleted
+type Reconciler struct {
+	provider *provider.Provider
+	path     string
+	format   string
+
+	// AllowEmpty allows an empty desired state to delete every record
+	AllowEmpty bool
+}
+
+// New creates a reconciler for the desired state at path, in the JSON or
+// YAML format
+func New(prov *provider.Provider, path, fileFormat string) *Reconciler {
+	return &Reconciler{
+		provider: prov,
+		path:     path,
+		format:   fileFormat,
+	}
+}
+
+// Plan reads the desired state and returns the changes to apply. Names of
+// the desired state outside of the domain filter are reported as warnings.
+func (r *Reconciler) Plan(ctx context.Context) (*webhook.Changes, []string, error) {
+	file, err := os.Open(r.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	desired, warnings, err := format.Parse(file, r.format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid desired state %s: %w", r.path, err)
+	}
+
+	// Same normalization and checks as the endpoints sent by external-dns
+	desired, err = r.provider.AdjustEndpoints(desired)
+	if err != nil {
+		return nil, nil, err
+	}
+	if problems := webhook.ValidateEndpoints(desired); len(problems) > 0 {
+		return nil, nil, fmt.Errorf("invalid desired state %s: %s", r.path, strings.Join(problems, "; "))
+	}
+	for _, endpoint := range desired {
+		if !r.provider.Manages(endpoint.DNSName) {
+			warnings = append(warnings, fmt.Sprintf("%s is outside of the domain filter and is ignored", endpoint.DNSName))
+		}
+	}
+	desired = diff.Filter(desired, r.provider.Manages)
+
+	// Only the first target of a name is written, compare that one alone
+	// so that the other targets do not make every pass an update
+	desired, dropped := diff.SingleTarget(desired)
+	warnings = append(warnings, dropped...)
+
+	records, err := r.provider.RefreshCache(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	current := diff.Filter(provider.Endpoints(records), r.provider.Manages)
+
+	if len(desired) == 0 && len(current) > 0 && !r.AllowEmpty {
+		return nil, warnings, fmt.Errorf("%w: refusing to delete %d records", ErrEmptyState, len(current))
+	}
+
+	return diff.Compute(current, desired), warnings, nil
+}
+
+// Once reconciles the records a single time. In dry-run mode, the changes
+// are only computed.
+func (r *Reconciler) Once(ctx context.Context, dryRun bool) (*webhook.Changes, []provider.ChangeResult, error) {
+	changes, warnings, err := r.Plan(ctx)
+	for _, warning := range warnings {
+		log.Printf("Warning: %s", warning)
+	}
+	if err != nil || dryRun || changes.IsEmpty() {
+		return changes, nil, err
+	}
+
+	results, err := r.provider.ApplyChanges(provider.WithCaller(ctx, Caller), changes)
+	return changes, results, err
+}
+
+// Run reconciles the records every interval and whenever the desired
+// state file changes, until ctx is done. Failures are logged and retried
+// on the next run.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	trigger := make(chan struct{}, 1)
+	go filewatch.Watch(ctx, filewatch.DefaultInterval, func() {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}, r.path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.run(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-trigger:
+			log.Printf("Desired state %s changed", r.path)
+		}
+	}
+}
+
+func (r *Reconciler) run(ctx context.Context) {
+	changes, results, err := r.Once(ctx, false)
+	if err != nil {
+		log.Printf("Failed to reconcile records: %v", err)
+		return
+	}
+	if changes.IsEmpty() {
+		log.Printf("Records match the desired state")
+		return
+	}
+
+	applied := 0
+	for _, result := range results {
+		if result.Status == provider.StatusApplied {
+			applied++
+		}
+	}
+	log.Printf("Reconciled records: %d of %d changes applied", applied, len(results))
+}
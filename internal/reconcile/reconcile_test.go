@@ -0,0 +1,151 @@
+package reconcile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/format"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// newTestProvider returns a provider backed by a fake usg-dns-api holding
+// a record within the domain filter and one outside of it
+func newTestProvider(t *testing.T) *provider.Provider {
+	t.Helper()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/records" {
+			w.Write([]byte(`[{"id":"1","name":"old.example.com","target":"1.1.1.1"},{"id":"2","name":"nas.other.org","target":"10.0.0.2"}]`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(gateway.Close)
+
+	client := usgdns.NewClient(gateway.URL, "test-token")
+	return provider.NewProvider(client, []string{"example.com"}, false)
+}
+
+func writeState(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "records.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPlan(t *testing.T) {
+	path := writeState(t, `
+- dnsName: www.example.com
+  targets: [192.168.1.10]
+- dnsName: outside.other.org
+  targets: [192.168.1.11]
+`)
+
+	changes, warnings, err := New(newTestProvider(t), path, format.YAML).Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Expected a warning for the name outside of the domain filter, got %v", warnings)
+	}
+	if len(changes.Create) != 1 || changes.Create[0].DNSName != "www.example.com" {
+		t.Errorf("Unexpected creates: %+v", changes.Create)
+	}
+	// nas.other.org is outside of the domain filter and must be left alone
+	if len(changes.Delete) != 1 || changes.Delete[0].DNSName != "old.example.com" {
+		t.Errorf("Unexpected deletes: %+v", changes.Delete)
+	}
+}
+
+func TestPlanRefusesEmptyState(t *testing.T) {
+	path := writeState(t, "")
+
+	r := New(newTestProvider(t), path, format.YAML)
+	if _, _, err := r.Plan(context.Background()); !errors.Is(err, ErrEmptyState) {
+		t.Fatalf("Expected ErrEmptyState, got %v", err)
+	}
+
+	r.AllowEmpty = true
+	changes, _, err := r.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if len(changes.Delete) != 1 {
+		t.Errorf("Expected every managed record to be deleted, got %+v", changes)
+	}
+}
+
+func TestOnceConvergesWithSeveralTargets(t *testing.T) {
+	// An in-memory usg-dns-api holding a single record per name
+	var mu sync.Mutex
+	records := []usgdns.Record{{ID: "1", Name: "old.example.com", Target: "1.1.1.1"}}
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var payload usgdns.Record
+		json.NewDecoder(r.Body).Decode(&payload)
+		id := strings.TrimPrefix(r.URL.Path, "/records/")
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(records)
+		case http.MethodPost:
+			payload.ID = strconv.Itoa(len(records) + 10)
+			records = append(records, payload)
+			json.NewEncoder(w).Encode(payload)
+		case http.MethodPut:
+			for i := range records {
+				if records[i].ID == id {
+					payload.ID = id
+					records[i] = payload
+				}
+			}
+			json.NewEncoder(w).Encode(payload)
+		}
+	}))
+	defer gateway.Close()
+
+	prov := provider.NewProvider(usgdns.NewClient(gateway.URL, "test-token"), []string{"example.com"}, false)
+	path := writeState(t, `
+- dnsName: old.example.com
+  targets: [2.2.2.2, 3.3.3.3]
+- dnsName: www.example.com
+  targets: [192.168.1.10, 192.168.1.11]
+`)
+	r := New(prov, path, format.YAML)
+
+	changes, _, err := r.Once(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Once failed: %v", err)
+	}
+	if len(changes.Create) != 1 || len(changes.UpdateNew) != 1 {
+		t.Errorf("Expected a create and an update, got %+v", changes)
+	}
+
+	changes, warnings, err := r.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if !changes.IsEmpty() {
+		t.Errorf("Expected nothing to do once the first targets are written, got %+v", changes)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("Expected a warning for each name with dropped targets, got %v", warnings)
+	}
+
+	if changes, _, err := r.Once(context.Background(), false); err != nil || !changes.IsEmpty() {
+		t.Errorf("Expected a second pass to change nothing, got %+v and %v", changes, err)
+	}
+}
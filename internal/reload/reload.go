@@ -0,0 +1,142 @@
+package reload
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/config"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/events"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/filewatch"
+)
+
+// Result is the data of a config.reloaded or config.rejected event
+type Result struct {
+	// Applied lists the settings changed without a restart
+	Applied []string `json:"applied,omitempty"`
+	// RestartRequired lists the changed settings which are only taken
+	// into account after a restart
+	RestartRequired []string `json:"restartRequired,omitempty"`
+	// Error is why the configuration was rejected
+	Error string `json:"error,omitempty"`
+}
+
+// Publisher receives the reload events
+type Publisher interface {
+	Publish(eventType string, data any) events.Event
+}
+
+// Reloader loads the configuration again and applies the settings which
+// changed. A configuration failing validation is rejected as a whole and
+// the running one is kept.
+type Reloader struct {
+	load  func() (*config.Config, error)
+	apply func(cfg *config.Config, changed []string)
+
+	mu        sync.Mutex
+	current   *config.Config
+	publisher Publisher
+}
+
+// Option configures optional reloader behavior
+type Option func(*Reloader)
+
+// WithPublisher publishes an event for each reload
+func WithPublisher(publisher Publisher) Option {
+	return func(r *Reloader) {
+		r.publisher = publisher
+	}
+}
+
+// New creates a reloader of the current configuration. load returns the
+// new configuration, and apply is called with it and the names of the
+// reloadable settings which changed.
+func New(current *config.Config, load func() (*config.Config, error), apply func(cfg *config.Config, changed []string), opts ...Option) *Reloader {
+	r := &Reloader{
+		load:    load,
+		apply:   apply,
+		current: current,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Reload loads and applies the configuration
+func (r *Reloader) Reload() (Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		log.Printf("Rejected reloaded configuration, keeping the running one: %v", err)
+		r.publish(events.TypeConfigRejected, Result{Error: err.Error()})
+		return Result{}, err
+	}
+
+	var result Result
+	for _, name := range r.current.Diff(cfg) {
+		if config.Reloadable(name) {
+			result.Applied = append(result.Applied, name)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, name)
+		}
+	}
+
+	if len(result.Applied) == 0 && len(result.RestartRequired) == 0 {
+		log.Printf("Reloaded configuration, nothing changed")
+		return result, nil
+	}
+	if len(result.RestartRequired) > 0 {
+		log.Printf("Configuration changes requiring a restart were ignored: %s", strings.Join(result.RestartRequired, ", "))
+	}
+
+	if len(result.Applied) > 0 {
+		r.apply(cfg, result.Applied)
+
+		// Settings requiring a restart keep their running value, so that
+		// they are reported again until the process is restarted
+		r.current = r.current.With(cfg, result.Applied)
+		log.Printf("Reloaded configuration, applied: %s", strings.Join(result.Applied, ", "))
+	}
+
+	r.publish(events.TypeConfigReloaded, result)
+	return result, nil
+}
+
+// Run reloads the configuration on SIGHUP, and when configFile changes if
+// it is set. It blocks until ctx is done.
+func (r *Reloader) Run(ctx context.Context, configFile string, interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	if configFile != "" {
+		go filewatch.Watch(ctx, interval, func() {
+			log.Printf("Config file %s changed, reloading", configFile)
+			r.Reload()
+		}, configFile)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			log.Printf("Received SIGHUP, reloading configuration")
+			r.Reload()
+		}
+	}
+}
+
+func (r *Reloader) publish(eventType string, result Result) {
+	if r.publisher != nil {
+		r.publisher.Publish(eventType, result)
+	}
+}
@@ -0,0 +1,79 @@
+package reload
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/config"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/events"
+)
+
+func TestReload(t *testing.T) {
+	current := &config.Config{URLs: []string{"http://gateway"}, Port: 8888, DomainFilter: []string{"a.lan"}}
+	next := *current
+	var loadErr error
+
+	var applied []string
+	var appliedFilter []string
+	broker := events.NewBroker(10)
+	reloader := New(current, func() (*config.Config, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		cfg := next
+		return &cfg, nil
+	}, func(cfg *config.Config, changed []string) {
+		applied = changed
+		appliedFilter = cfg.DomainFilter
+	}, WithPublisher(broker))
+
+	// Reloadable settings are applied, the others are reported
+	next.DomainFilter = []string{"b.lan"}
+	next.DryRun = true
+	next.Port = 9999
+	result, err := reloader.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if !slices.Equal(result.Applied, []string{"DOMAIN_FILTER", "DRY_RUN"}) || !slices.Equal(applied, result.Applied) {
+		t.Errorf("Unexpected applied settings %v (apply got %v)", result.Applied, applied)
+	}
+	if !slices.Equal(appliedFilter, []string{"b.lan"}) {
+		t.Errorf("Expected the new domain filter to be applied, got %v", appliedFilter)
+	}
+	if !slices.Equal(result.RestartRequired, []string{"SERVER_PORT"}) {
+		t.Errorf("Expected SERVER_PORT to require a restart, got %v", result.RestartRequired)
+	}
+
+	// Applied settings are not applied again, the others are still reported
+	applied = nil
+	result, err = reloader.Reload()
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if applied != nil || len(result.Applied) != 0 || len(result.RestartRequired) != 1 {
+		t.Errorf("Unexpected second reload %+v (apply got %v)", result, applied)
+	}
+
+	// An invalid configuration is rejected without applying anything
+	loadErr = errors.New("invalid DRY_RUN")
+	next.DomainFilter = []string{"c.lan"}
+	if _, err := reloader.Reload(); !errors.Is(err, loadErr) {
+		t.Errorf("Expected the load error, got %v", err)
+	}
+	if applied != nil {
+		t.Errorf("Expected nothing to be applied, got %v", applied)
+	}
+
+	replay, _, cancel := broker.Subscribe(0)
+	cancel()
+	var types []string
+	for _, event := range replay {
+		types = append(types, event.Type)
+	}
+	want := []string{events.TypeConfigReloaded, events.TypeConfigReloaded, events.TypeConfigRejected}
+	if !slices.Equal(types, want) {
+		t.Errorf("Expected events %v, got %v", want, types)
+	}
+}
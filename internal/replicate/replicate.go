@@ -0,0 +1,418 @@
+// Package replicate keeps the records of a secondary usg-dns-api instance
+// in line with those of the primary.
+package replicate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// Replication modes
+const (
+	// ModeOneWay makes the secondary hold exactly the records of the
+	// primary
+	ModeOneWay = "one-way"
+	// ModeMerge copies the names found on a single side to the other one,
+	// and resolves the names whose targets differ in favor of the authority.
+	// Passes keep no state, so a name deleted on one side cannot be told
+	// from a name created on the other: merge never carries deletions, and
+	// a deleted name is copied back whatever the authority.
+	ModeMerge = "merge"
+)
+
+// Authorities deciding the conflicts in merge mode
+const (
+	AuthorityPrimary   = "primary"
+	AuthoritySecondary = "secondary"
+	// AuthorityNone leaves conflicts as they are, only reporting them
+	AuthorityNone = "none"
+)
+
+// Actions of an operation
+const (
+	ActionCreate = "create"
+	ActionDelete = "delete"
+)
+
+var (
+	// ErrEmptyPrimary is returned in one-way mode when the primary holds no
+	// record within the domain filter while the secondary does, which more
+	// likely means a reset gateway than a wish to delete every record
+	ErrEmptyPrimary = errors.New("primary holds no record")
+
+	// ErrTooManyDeletions is returned when a pass would delete more records
+	// than allowed
+	ErrTooManyDeletions = errors.New("too many deletions")
+)
+
+// Instance is a usg-dns-api instance taking part in the replication
+type Instance struct {
+	URL    string
+	Client *usgdns.Client
+}
+
+// Options configure a Replicator
+type Options struct {
+	// Mode is ModeOneWay or ModeMerge
+	Mode string
+	// Authority decides the conflicts in merge mode. One-way mode always
+	// favors the primary.
+	Authority string
+	// Manages reports whether a name is within the domain filter. Nil
+	// manages every name.
+	Manages func(name string) bool
+	// MaxDeletions is the largest number of deletions of a pass, 0 meaning
+	// no limit
+	MaxDeletions int
+	// DryRun reports whether passes should only be planned. Nil never
+	// does.
+	DryRun func() bool
+}
+
+// Operation is a record created on or deleted from an instance
+type Operation struct {
+	Action string        `json:"action"`
+	URL    string        `json:"url"`
+	Record usgdns.Record `json:"record"`
+}
+
+func (o Operation) String() string {
+	return fmt.Sprintf("%s %s -> %s on %s", o.Action, o.Record.Name, o.Record.Target, o.URL)
+}
+
+// Conflict is a name with different targets on the two instances. Winner
+// is the instance whose targets are kept, empty when the conflict is left
+// unresolved.
+type Conflict struct {
+	Name      string   `json:"name"`
+	Primary   []string `json:"primary"`
+	Secondary []string `json:"secondary"`
+	Winner    string   `json:"winner,omitempty"`
+}
+
+func (c Conflict) String() string {
+	s := fmt.Sprintf("%s has %v on the primary and %v on the secondary", c.Name, c.Primary, c.Secondary)
+	if c.Winner == "" {
+		return s + ", left as is"
+	}
+	return s + ", keeping the " + c.Winner
+}
+
+// Plan is what a pass does: creates first, so that a name being realigned
+// never disappears, then deletes
+type Plan struct {
+	Operations []Operation `json:"operations"`
+	Conflicts  []Conflict  `json:"conflicts"`
+}
+
+// IsEmpty reports whether the instances already hold the same records
+func (p *Plan) IsEmpty() bool {
+	return len(p.Operations) == 0 && len(p.Conflicts) == 0
+}
+
+// unresolved counts the conflicts left as they are
+func (p *Plan) unresolved() int {
+	n := 0
+	for _, conflict := range p.Conflicts {
+		if conflict.Winner == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// Status is the outcome of the last passes
+type Status struct {
+	// LastRun is the time of the last pass, successful or not
+	LastRun time.Time
+	// InSyncAt is the last time the secondary was found or made to hold
+	// the records of the primary
+	InSyncAt time.Time
+	// Divergence counts the operations the last pass found necessary
+	Divergence int
+	// Conflicts counts the conflicts found by the last pass
+	Conflicts int
+	// Failures counts the passes which failed
+	Failures  uint64
+	LastError string
+}
+
+// Replicator replicates the records within the domain filter between a
+// primary and a secondary instance
+type Replicator struct {
+	primary   Instance
+	secondary Instance
+	opts      Options
+	now       func() time.Time
+	started   time.Time
+
+	mu     sync.Mutex
+	status Status
+}
+
+// New creates a replicator from primary to secondary
+func New(primary, secondary Instance, opts Options) *Replicator {
+	if opts.Mode == "" {
+		opts.Mode = ModeOneWay
+	}
+	if opts.Authority == "" || opts.Mode == ModeOneWay {
+		opts.Authority = AuthorityPrimary
+	}
+	if opts.Manages == nil {
+		opts.Manages = func(string) bool { return true }
+	}
+
+	return &Replicator{
+		primary:   primary,
+		secondary: secondary,
+		opts:      opts,
+		now:       time.Now,
+		started:   time.Now(),
+	}
+}
+
+// Secondary returns the URL of the secondary instance
+func (r *Replicator) Secondary() string {
+	return r.secondary.URL
+}
+
+// Status returns the outcome of the last passes
+func (r *Replicator) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.status
+}
+
+// Lag returns how long the secondary has not been known to hold the
+// records of the primary, counted from the creation of the replicator
+// until a first pass succeeds
+func (r *Replicator) Lag() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	since := r.status.InSyncAt
+	if since.IsZero() {
+		since = r.started
+	}
+	return r.now().Sub(since)
+}
+
+// Plan reads both inventories and returns what a pass would do
+func (r *Replicator) Plan(ctx context.Context) (*Plan, error) {
+	primaryRecords, err := r.primary.Client.GetRecords(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the records of %s: %w", r.primary.URL, err)
+	}
+	secondaryRecords, err := r.secondary.Client.GetRecords(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the records of %s: %w", r.secondary.URL, err)
+	}
+
+	primary, secondary := r.byName(primaryRecords), r.byName(secondaryRecords)
+	if r.opts.Mode == ModeOneWay && len(primary) == 0 && len(secondary) > 0 {
+		return nil, fmt.Errorf("%w: refusing to delete the %d names of %s", ErrEmptyPrimary, len(secondary), r.secondary.URL)
+	}
+
+	plan := &Plan{}
+	for _, name := range unionNames(primary, secondary) {
+		onPrimary, onSecondary := primary[name], secondary[name]
+		switch {
+		case len(onSecondary) == 0:
+			plan.align(onPrimary, nil, r.secondary.URL)
+		case len(onPrimary) == 0 && r.opts.Mode == ModeOneWay:
+			plan.align(nil, onSecondary, r.secondary.URL)
+		case len(onPrimary) == 0:
+			plan.align(onSecondary, nil, r.primary.URL)
+		case !slices.Equal(targets(onPrimary), targets(onSecondary)):
+			conflict := Conflict{Name: name, Primary: targets(onPrimary), Secondary: targets(onSecondary)}
+			switch r.opts.Authority {
+			case AuthorityPrimary:
+				conflict.Winner = AuthorityPrimary
+				plan.align(onPrimary, onSecondary, r.secondary.URL)
+			case AuthoritySecondary:
+				conflict.Winner = AuthoritySecondary
+				plan.align(onSecondary, onPrimary, r.primary.URL)
+			}
+			plan.Conflicts = append(plan.Conflicts, conflict)
+		}
+	}
+
+	// Creates first, each group keeping the order of the names
+	sort.SliceStable(plan.Operations, func(i, j int) bool {
+		return plan.Operations[i].Action == ActionCreate && plan.Operations[j].Action == ActionDelete
+	})
+
+	if r.opts.MaxDeletions > 0 {
+		deletions := 0
+		for _, op := range plan.Operations {
+			if op.Action == ActionDelete {
+				deletions++
+			}
+		}
+		if deletions > r.opts.MaxDeletions {
+			return plan, fmt.Errorf("%w: pass deletes %d records, more than the limit of %d", ErrTooManyDeletions, deletions, r.opts.MaxDeletions)
+		}
+	}
+
+	return plan, nil
+}
+
+// Once runs a single pass. In dry-run mode, the plan is only computed.
+func (r *Replicator) Once(ctx context.Context, dryRun bool) (plan *Plan, err error) {
+	defer func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.status.LastRun = r.now()
+		r.status.LastError = ""
+		if plan != nil {
+			r.status.Divergence = len(plan.Operations)
+			r.status.Conflicts = len(plan.Conflicts)
+		}
+		switch {
+		case err != nil:
+			r.status.Failures++
+			r.status.LastError = err.Error()
+		case plan.unresolved() > 0, dryRun && len(plan.Operations) > 0:
+			// The instances still differ
+		default:
+			r.status.InSyncAt = r.now()
+		}
+	}()
+
+	plan, err = r.Plan(ctx)
+	if err != nil || dryRun {
+		return plan, err
+	}
+
+	for _, op := range plan.Operations {
+		if err := r.apply(ctx, op); err != nil {
+			return plan, fmt.Errorf("failed to %s: %w", op, err)
+		}
+	}
+	return plan, nil
+}
+
+// Run replicates every interval until ctx is done. Failures are logged and
+// retried on the next pass.
+func (r *Replicator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.run(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Replicator) run(ctx context.Context) {
+	dryRun := r.opts.DryRun != nil && r.opts.DryRun()
+	plan, err := r.Once(ctx, dryRun)
+	if plan != nil {
+		for _, conflict := range plan.Conflicts {
+			log.Printf("Replication conflict: %s", conflict)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to replicate records to %s: %v", r.secondary.URL, err)
+		return
+	}
+
+	switch {
+	case len(plan.Operations) == 0:
+		log.Printf("Records of %s match %s", r.secondary.URL, r.primary.URL)
+	case dryRun:
+		for _, op := range plan.Operations {
+			log.Printf("[DRY RUN] Would %s", op)
+		}
+	default:
+		log.Printf("Replicated records to %s: %d changes applied", r.secondary.URL, len(plan.Operations))
+	}
+}
+
+// apply creates or deletes a record on its instance
+func (r *Replicator) apply(ctx context.Context, op Operation) error {
+	instance := r.primary
+	if op.URL == r.secondary.URL {
+		instance = r.secondary
+	}
+
+	if op.Action == ActionCreate {
+		_, err := instance.Client.CreateRecord(ctx, op.Record.Name, op.Record.Target)
+		return err
+	}
+	return instance.Client.DeleteRecord(ctx, op.Record)
+}
+
+// byName indexes the records within the domain filter by name
+func (r *Replicator) byName(records []usgdns.Record) map[string][]usgdns.Record {
+	m := make(map[string][]usgdns.Record)
+	for _, record := range records {
+		if r.opts.Manages(record.Name) {
+			m[record.Name] = append(m[record.Name], record)
+		}
+	}
+	return m
+}
+
+// align adds the operations making the records of a name on the instance
+// at url, have, match the targets of want
+func (p *Plan) align(want, have []usgdns.Record, url string) {
+	wanted, present := targets(want), targets(have)
+	for _, target := range wanted {
+		if !slices.Contains(present, target) {
+			p.Operations = append(p.Operations, Operation{
+				Action: ActionCreate,
+				URL:    url,
+				Record: usgdns.Record{Name: want[0].Name, Target: target},
+			})
+		}
+	}
+	for _, record := range have {
+		if !slices.Contains(wanted, record.Target) {
+			p.Operations = append(p.Operations, Operation{Action: ActionDelete, URL: url, Record: record})
+		}
+	}
+}
+
+// targets returns the sorted distinct targets of records
+func targets(records []usgdns.Record) []string {
+	var t []string
+	for _, record := range records {
+		if !slices.Contains(t, record.Target) {
+			t = append(t, record.Target)
+		}
+	}
+	slices.Sort(t)
+	return t
+}
+
+// unionNames returns the names of both indexes, sorted so that plans are
+// stable
+func unionNames(a, b map[string][]usgdns.Record) []string {
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
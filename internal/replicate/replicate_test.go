@@ -0,0 +1,207 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// fakeGateway is an in-memory usg-dns-api
+type fakeGateway struct {
+	mu      sync.Mutex
+	records []usgdns.Record
+	nextID  int
+}
+
+// newInstance starts a fake usg-dns-api holding records given as
+// name=target
+func newInstance(t *testing.T, records ...string) (Instance, *fakeGateway) {
+	t.Helper()
+
+	g := &fakeGateway{nextID: 100}
+	for i, record := range records {
+		name, target, _ := strings.Cut(record, "=")
+		g.records = append(g.records, usgdns.Record{ID: strconv.Itoa(i + 1), Name: name, Target: target})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		switch r.Method {
+		case http.MethodGet:
+			json.NewEncoder(w).Encode(g.records)
+		case http.MethodPost:
+			var record usgdns.Record
+			json.NewDecoder(r.Body).Decode(&record)
+			g.nextID++
+			record.ID = strconv.Itoa(g.nextID)
+			g.records = append(g.records, record)
+			json.NewEncoder(w).Encode(record)
+		case http.MethodDelete:
+			id := strings.TrimPrefix(r.URL.Path, "/records/")
+			g.records = slices.DeleteFunc(g.records, func(record usgdns.Record) bool { return record.ID == id })
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return Instance{URL: server.URL, Client: usgdns.NewClient(server.URL, "token")}, g
+}
+
+// content returns the records of the gateway as sorted name=target
+func (g *fakeGateway) content() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var content []string
+	for _, record := range g.records {
+		content = append(content, record.Name+"="+record.Target)
+	}
+	slices.Sort(content)
+	return content
+}
+
+func manages(name string) bool {
+	return strings.HasSuffix(name, ".lan")
+}
+
+func TestOneWay(t *testing.T) {
+	primary, _ := newInstance(t, "a.lan=10.0.0.1", "b.lan=10.0.0.2", "c.lan=10.0.0.3")
+	secondary, gateway := newInstance(t, "b.lan=10.0.0.9", "c.lan=10.0.0.3", "old.lan=10.0.0.4", "nas.other=10.0.0.5")
+	r := New(primary, secondary, Options{Manages: manages})
+
+	plan, err := r.Once(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Once failed: %v", err)
+	}
+	if len(plan.Operations) != 4 || plan.Operations[0].Action != ActionCreate || plan.Operations[3].Action != ActionDelete {
+		t.Errorf("Expected two creates then two deletes, got %v", plan.Operations)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].Name != "b.lan" || plan.Conflicts[0].Winner != AuthorityPrimary {
+		t.Errorf("Expected a conflict on b.lan won by the primary, got %+v", plan.Conflicts)
+	}
+
+	want := []string{"a.lan=10.0.0.1", "b.lan=10.0.0.2", "c.lan=10.0.0.3", "nas.other=10.0.0.5"}
+	if got := gateway.content(); !slices.Equal(got, want) {
+		t.Errorf("Expected the secondary to match the primary within the domain filter, got %v", got)
+	}
+
+	status := r.Status()
+	if status.Divergence != 4 || status.Conflicts != 1 || status.InSyncAt.IsZero() {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	plan, err = r.Once(context.Background(), false)
+	if err != nil || !plan.IsEmpty() {
+		t.Errorf("Expected a second pass to find nothing to do, got %+v and %v", plan, err)
+	}
+}
+
+func TestOneWayEmptyPrimary(t *testing.T) {
+	primary, _ := newInstance(t)
+	secondary, gateway := newInstance(t, "a.lan=10.0.0.1")
+	r := New(primary, secondary, Options{Manages: manages})
+
+	if _, err := r.Once(context.Background(), false); !errors.Is(err, ErrEmptyPrimary) {
+		t.Fatalf("Expected ErrEmptyPrimary, got %v", err)
+	}
+	if len(gateway.content()) != 1 {
+		t.Error("Expected the secondary to be left alone")
+	}
+	if status := r.Status(); status.Failures != 1 || status.LastError == "" {
+		t.Errorf("Expected the failure to be counted, got %+v", status)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	primary, primaryGateway := newInstance(t, "a.lan=10.0.0.1", "b.lan=10.0.0.2")
+	secondary, secondaryGateway := newInstance(t, "b.lan=10.0.0.9", "s.lan=10.0.0.3")
+
+	r := New(primary, secondary, Options{Mode: ModeMerge, Authority: AuthorityNone, Manages: manages})
+	plan, err := r.Once(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Once failed: %v", err)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].Winner != "" {
+		t.Errorf("Expected an unresolved conflict, got %+v", plan.Conflicts)
+	}
+	if got := primaryGateway.content(); !slices.Equal(got, []string{"a.lan=10.0.0.1", "b.lan=10.0.0.2", "s.lan=10.0.0.3"}) {
+		t.Errorf("Expected the name of the secondary to be copied to the primary, got %v", got)
+	}
+	if got := secondaryGateway.content(); !slices.Equal(got, []string{"a.lan=10.0.0.1", "b.lan=10.0.0.9", "s.lan=10.0.0.3"}) {
+		t.Errorf("Expected the conflict to be left as is, got %v", got)
+	}
+	if !r.Status().InSyncAt.IsZero() {
+		t.Error("Expected an unresolved conflict to keep the instances out of sync")
+	}
+
+	r = New(primary, secondary, Options{Mode: ModeMerge, Authority: AuthoritySecondary, Manages: manages})
+	if _, err := r.Once(context.Background(), false); err != nil {
+		t.Fatalf("Once failed: %v", err)
+	}
+	if got := primaryGateway.content(); !slices.Equal(got, secondaryGateway.content()) || !slices.Contains(got, "b.lan=10.0.0.9") {
+		t.Errorf("Expected the targets of the secondary to win, got %v", got)
+	}
+}
+
+func TestMergeKeepsDeletedNames(t *testing.T) {
+	// d.lan was deleted on the primary, which a pass cannot tell from a
+	// name created on the secondary
+	primary, primaryGateway := newInstance(t, "a.lan=10.0.0.1")
+	secondary, secondaryGateway := newInstance(t, "a.lan=10.0.0.1", "d.lan=10.0.0.4")
+
+	r := New(primary, secondary, Options{Mode: ModeMerge, Authority: AuthorityPrimary, Manages: manages})
+	plan, err := r.Once(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Once failed: %v", err)
+	}
+	for _, op := range plan.Operations {
+		if op.Action == ActionDelete {
+			t.Errorf("Expected merge mode never to delete, got %v", op)
+		}
+	}
+	want := []string{"a.lan=10.0.0.1", "d.lan=10.0.0.4"}
+	if got := primaryGateway.content(); !slices.Equal(got, want) {
+		t.Errorf("Expected the deleted name to be copied back to the primary, got %v", got)
+	}
+	if got := secondaryGateway.content(); !slices.Equal(got, want) {
+		t.Errorf("Expected the secondary to be left as is, got %v", got)
+	}
+}
+
+func TestLag(t *testing.T) {
+	primary, _ := newInstance(t, "a.lan=10.0.0.1")
+	secondary, _ := newInstance(t)
+	r := New(primary, secondary, Options{Manages: manages})
+
+	now := r.started.Add(time.Minute)
+	r.now = func() time.Time { return now }
+	if lag := r.Lag(); lag != time.Minute {
+		t.Errorf("Expected the lag to count from the start, got %s", lag)
+	}
+
+	if _, err := r.Once(context.Background(), true); err != nil {
+		t.Fatalf("Once failed: %v", err)
+	}
+	if lag := r.Lag(); lag != time.Minute {
+		t.Errorf("Expected a dry run with differences to leave the lag, got %s", lag)
+	}
+
+	if _, err := r.Once(context.Background(), false); err != nil {
+		t.Fatalf("Once failed: %v", err)
+	}
+	if lag := r.Lag(); lag != 0 {
+		t.Errorf("Expected no lag after a pass, got %s", lag)
+	}
+}
@@ -0,0 +1,76 @@
+// Package selector filters usg-dns-api records by name and target.
+package selector
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// Selector matches records whose name matches a glob and whose target is
+// within a network. An empty selector matches every record.
+type Selector struct {
+	name    string
+	network *net.IPNet
+}
+
+// Parse creates a selector from a name glob, such as *.example.com, and a
+// target CIDR, such as 192.168.1.0/24. Either can be empty.
+func Parse(nameGlob, cidr string) (*Selector, error) {
+	s := &Selector{name: labels(nameGlob)}
+	if _, err := path.Match(s.name, ""); err != nil {
+		return nil, fmt.Errorf("invalid name glob %q: %w", nameGlob, err)
+	}
+
+	if cidr != "" {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target CIDR %q: %w", cidr, err)
+		}
+		s.network = network
+	}
+	return s, nil
+}
+
+// IsEmpty reports whether the selector matches every record
+func (s *Selector) IsEmpty() bool {
+	return s.name == "" && s.network == nil
+}
+
+// Match reports whether the record is selected. The * of the glob matches
+// any characters but dots, so *.example.com selects a.example.com and not
+// a.b.example.com.
+func (s *Selector) Match(record usgdns.Record) bool {
+	if s.name != "" {
+		if ok, _ := path.Match(s.name, labels(record.Name)); !ok {
+			return false
+		}
+	}
+	if s.network != nil {
+		ip := net.ParseIP(record.Target)
+		if ip == nil || !s.network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Filter returns the selected records
+func (s *Selector) Filter(records []usgdns.Record) []usgdns.Record {
+	var selected []usgdns.Record
+	for _, record := range records {
+		if s.Match(record) {
+			selected = append(selected, record)
+		}
+	}
+	return selected
+}
+
+// labels turns the dots of a name into slashes, for path.Match to match
+// the wildcards of a glob within a single label
+func labels(name string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(name, "."), ".", "/")
+}
@@ -0,0 +1,56 @@
+package selector
+
+import (
+	"testing"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+func TestSelectorMatch(t *testing.T) {
+	records := []usgdns.Record{
+		{ID: "1", Name: "a.example.com", Target: "192.168.1.10"},
+		{ID: "2", Name: "b.example.com", Target: "10.0.0.1"},
+		{ID: "3", Name: "a.b.example.com", Target: "192.168.1.20"},
+		{ID: "4", Name: "a.other.com", Target: "192.168.1.30"},
+	}
+
+	tests := []struct {
+		name, glob, cidr string
+		want             []string
+	}{
+		{"empty", "", "", []string{"1", "2", "3", "4"}},
+		{"glob", "*.example.com", "", []string{"1", "2"}},
+		{"glob with trailing dot", "*.b.example.com.", "", []string{"3"}},
+		{"cidr", "", "192.168.1.0/24", []string{"1", "3", "4"}},
+		{"both", "*.example.com", "192.168.0.0/16", []string{"1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.glob, tt.cidr)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			var got []string
+			for _, record := range s.Filter(records) {
+				got = append(got, record.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse("[a", ""); err == nil {
+		t.Error("Expected an error for an invalid glob")
+	}
+	if _, err := Parse("", "192.168.1.0"); err == nil {
+		t.Error("Expected an error for an address without prefix length")
+	}
+}
@@ -0,0 +1,361 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/auth"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/diff"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/format"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/requestid"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/snapshot"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/version"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// adminStatus is the runtime state reported by the admin API
+type adminStatus struct {
+	Version      string     `json:"version"`
+	DryRun       bool       `json:"dryRun"`
+	Paused       bool       `json:"paused"`
+	DomainFilter []string   `json:"domainFilter"`
+	CachedAt     *time.Time `json:"cachedAt,omitempty"`
+}
+
+// WithAdmin enables the admin API on a separate listener. Every admin
+// request must be authenticated by authenticator.
+func WithAdmin(bindAddress string, port int, authenticator auth.Authenticator) Option {
+	return func(s *Server) {
+		s.adminBindAddress = bindAddress
+		s.adminPort = port
+		s.adminAuth = authenticator
+	}
+}
+
+// WithEvents streams the events of broker on the /events route of the
+// admin API
+func WithEvents(broker http.Handler) Option {
+	return func(s *Server) {
+		s.events = broker
+	}
+}
+
+// WithSnapshots lists the snapshots of store on the admin API and allows
+// restoring them
+func WithSnapshots(store *snapshot.Store) Option {
+	return func(s *Server) {
+		s.snapshots = store
+	}
+}
+
+// adminHandler returns the routes of the admin API
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	if s.events != nil {
+		mux.Handle("/events", s.events)
+	}
+
+	mux.HandleFunc("/admin/status", s.adminGetStatus)
+	mux.HandleFunc("/admin/records", s.adminGetRecords)
+	mux.HandleFunc("/admin/changes", s.adminGetChanges)
+	mux.HandleFunc("/admin/changes/last", s.adminGetLastChanges)
+	mux.HandleFunc("/admin/cache/refresh", s.adminRefreshCache)
+	mux.HandleFunc("/admin/dry-run", s.adminDryRun)
+	mux.HandleFunc("/admin/pause", s.adminSetPaused(true))
+	mux.HandleFunc("/admin/resume", s.adminSetPaused(false))
+	mux.HandleFunc("/admin/export", s.adminExport)
+
+	if s.snapshots != nil {
+		mux.HandleFunc("/admin/snapshots", s.adminGetSnapshots)
+		mux.HandleFunc("/admin/snapshots/{id}/restore", s.adminRestoreSnapshot)
+	}
+
+	return mux
+}
+
+// startAdminServer starts the admin API server
+func (s *Server) startAdminServer() error {
+	handler := auth.Middleware(s.adminAuth, s.adminHandler(), func(w http.ResponseWriter, r *http.Request, err error) {
+		writeError(w, r, http.StatusUnauthorized, errorResponse{Code: codeUnauthorized, Message: "Unauthorized"})
+	})
+
+	addr := net.JoinHostPort(s.adminBindAddress, strconv.Itoa(s.adminPort))
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   requestid.Middleware(s.loggingMiddleware(handler)),
+		TLSConfig: s.tlsConfig,
+	}
+
+	if s.tlsConfig != nil {
+		log.Printf("Starting admin server on %s (TLS)", addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+
+	log.Printf("Starting admin server on %s", addr)
+	return srv.ListenAndServe()
+}
+
+func (s *Server) adminGetStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+func (s *Server) adminGetRecords(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	records, err := s.provider.ManagedRecords(r.Context())
+	if err != nil {
+		status, code := classifyError(err)
+		writeError(w, r, status, errorResponse{Code: code, Message: fmt.Sprintf("Failed to get records: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
+
+func (s *Server) adminGetChanges(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, s.provider.History())
+}
+
+func (s *Server) adminGetLastChanges(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	batch := s.provider.LastBatch()
+	if batch == nil {
+		writeError(w, r, http.StatusNotFound, errorResponse{Code: "not_found", Message: "No changes received yet"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, batch)
+}
+
+func (s *Server) adminRefreshCache(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	records, err := s.provider.RefreshCache(r.Context())
+	if err != nil {
+		status, code := classifyError(err)
+		writeError(w, r, status, errorResponse{Code: code, Message: fmt.Sprintf("Failed to refresh cache: %v", err)})
+		return
+	}
+
+	log.Printf("Admin %s refreshed the cache: %d records", auth.SubjectFromContext(r.Context()), len(records))
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+func (s *Server) adminDryRun(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.Policy().MaxBodyBytes)).Decode(&body); err != nil || body.Enabled == nil {
+			writeError(w, r, http.StatusBadRequest, errorResponse{Code: codeInvalidRequest, Message: `Expected a body such as {"enabled": true}`})
+			return
+		}
+
+		s.provider.SetDryRun(*body.Enabled)
+		log.Printf("Admin %s set dry-run to %v", auth.SubjectFromContext(r.Context()), *body.Enabled)
+	}
+
+	writeJSON(w, http.StatusOK, s.status())
+}
+
+func (s *Server) adminSetPaused(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		s.provider.SetPaused(paused)
+		if paused {
+			log.Printf("Admin %s paused applying changes", auth.SubjectFromContext(r.Context()))
+		} else {
+			log.Printf("Admin %s resumed applying changes", auth.SubjectFromContext(r.Context()))
+		}
+
+		writeJSON(w, http.StatusOK, s.status())
+	}
+}
+
+func (s *Server) adminExport(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	exportFormat := query.Get("format")
+	if exportFormat == "" {
+		exportFormat = format.Zone
+	}
+	if !slices.Contains(format.Formats, exportFormat) {
+		writeError(w, r, http.StatusBadRequest, errorResponse{
+			Code:    codeInvalidRequest,
+			Message: fmt.Sprintf("Unknown format %q, expected one of %s", exportFormat, strings.Join(format.Formats, ", ")),
+		})
+		return
+	}
+
+	endpoints, err := s.provider.GetRecords(r.Context())
+	if err != nil {
+		status, code := classifyError(err)
+		writeError(w, r, status, errorResponse{Code: code, Message: fmt.Sprintf("Failed to get records: %v", err)})
+		return
+	}
+	endpoints = diff.Filter(endpoints, s.provider.Manages)
+
+	// Render to a buffer first so that errors can still be reported
+	var buf bytes.Buffer
+	zones := s.provider.GetDomainFilter().Filters
+	if zone := query.Get("zone"); zone != "" {
+		records := zoneRecords(endpoints, zones, zone)
+		if exportFormat == format.Zone {
+			err = format.RenderZone(&buf, records)
+		} else {
+			err = format.Render(&buf, exportFormat, records.Endpoints, zones)
+		}
+	} else {
+		err = format.Render(&buf, exportFormat, endpoints, zones)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errorResponse{Code: codeInternalError, Message: fmt.Sprintf("Failed to render records: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType(exportFormat))
+	w.WriteHeader(http.StatusOK)
+	if _, err := buf.WriteTo(w); err != nil {
+		log.Printf("Failed to write export: %v", err)
+	}
+}
+
+// zoneRecords returns the records of a single zone of the domain filter
+func zoneRecords(endpoints []*webhook.Endpoint, zones []string, zone string) format.ZoneRecords {
+	for _, records := range format.SplitZones(endpoints, zones) {
+		if records.Name == zone {
+			return records
+		}
+	}
+	return format.ZoneRecords{Name: zone}
+}
+
+func (s *Server) adminGetSnapshots(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	snapshots, err := s.snapshots.List()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errorResponse{Code: codeInternalError, Message: fmt.Sprintf("Failed to list snapshots: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snapshots)
+}
+
+// restoreResponse is the outcome of restoring a snapshot
+type restoreResponse struct {
+	Snapshot string                  `json:"snapshot"`
+	DryRun   bool                    `json:"dryRun"`
+	Changes  *webhook.Changes        `json:"changes"`
+	Results  []provider.ChangeResult `json:"results,omitempty"`
+}
+
+func (s *Server) adminRestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun"))
+
+	snap, err := s.snapshots.Load(r.PathValue("id"))
+	if errors.Is(err, snapshot.ErrNotFound) {
+		writeError(w, r, http.StatusNotFound, errorResponse{Code: "not_found", Message: err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, errorResponse{Code: codeInternalError, Message: fmt.Sprintf("Failed to load snapshot: %v", err)})
+		return
+	}
+
+	ctx := provider.WithCaller(r.Context(), "admin:"+caller(r))
+	changes, results, err := snapshot.Restore(ctx, s.provider, snap, dryRun)
+	if err != nil {
+		status, code := classifyError(err)
+		writeError(w, r, status, errorResponse{
+			Code:    code,
+			Message: fmt.Sprintf("Failed to restore snapshot %s: %v", snap.ID, err),
+			Results: results,
+		})
+		return
+	}
+
+	if !dryRun {
+		log.Printf("Admin %s restored snapshot %s", auth.SubjectFromContext(r.Context()), snap.ID)
+	}
+	writeJSON(w, http.StatusOK, restoreResponse{
+		Snapshot: snap.ID,
+		DryRun:   dryRun,
+		Changes:  changes,
+		Results:  results,
+	})
+}
+
+func (s *Server) status() adminStatus {
+	status := adminStatus{
+		Version:      version.VersionFull(),
+		DryRun:       s.provider.DryRun(),
+		Paused:       s.provider.Paused(),
+		DomainFilter: s.provider.GetDomainFilter().Filters,
+	}
+	if cachedAt := s.provider.CachedAt(); !cachedAt.IsZero() {
+		status.CachedAt = &cachedAt
+	}
+	return status
+}
+
+// allowMethod writes a 405 response unless the request uses one of methods
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	writeError(w, r, http.StatusMethodNotAllowed, errorResponse{Code: codeMethodNotAllowed, Message: "Method not allowed"})
+	return false
+}
+
+// writeJSON sends v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
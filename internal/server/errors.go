@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/requestid"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// Machine-readable error codes
+const (
+	codeMethodNotAllowed     = "method_not_allowed"
+	codeNotAcceptable        = "not_acceptable"
+	codeUnsupportedMediaType = "unsupported_media_type"
+	codePayloadTooLarge      = "payload_too_large"
+	codeInvalidRequest       = "invalid_request"
+	codeValidationFailed     = "validation_failed"
+	codeUnauthorized         = "unauthorized"
+	codeInvalidChange        = "invalid_change"
+	codeRecordNotFound       = "record_not_found"
+	codePaused               = "paused"
+	codeTooManyDeletions     = "too_many_deletions"
+	codeBackendUnavailable   = "backend_unavailable"
+	codeBackendTimeout       = "backend_timeout"
+	codeBackendUnauthorized  = "backend_unauthorized"
+	codeBackendRejected      = "backend_rejected"
+	codeBackendError         = "backend_error"
+	codeInternalError        = "internal_error"
+)
+
+// errorResponse is the JSON body sent along with every error
+type errorResponse struct {
+	Code      string                  `json:"code"`
+	Message   string                  `json:"message"`
+	RequestID string                  `json:"requestId,omitempty"`
+	Details   []string                `json:"details,omitempty"`
+	Results   []provider.ChangeResult `json:"results,omitempty"`
+}
+
+// writeError sends a JSON error response
+func writeError(w http.ResponseWriter, r *http.Request, status int, body errorResponse) {
+	body.RequestID = requestid.FromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to encode error response: %v", err)
+	}
+}
+
+// writePayloadTooLarge answers a request whose body exceeds limit bytes
+func writePayloadTooLarge(w http.ResponseWriter, r *http.Request, limit int64) {
+	writeError(w, r, http.StatusRequestEntityTooLarge, errorResponse{
+		Code:    codePayloadTooLarge,
+		Message: fmt.Sprintf("Request body larger than %d bytes", limit),
+	})
+}
+
+// classifyError maps an error returned by the provider to an HTTP status
+// and an error code.
+//
+// external-dns retries on 5xx responses only, so errors that may go away
+// by themselves (gateway down, slow or misconfigured) are reported as 5xx
+// while changes that will never succeed as is are reported as 4xx.
+func classifyError(err error) (int, string) {
+	var apiErr *usgdns.APIError
+	var transportErr *usgdns.TransportError
+	var netErr net.Error
+
+	switch {
+	case errors.Is(err, provider.ErrInvalidChange):
+		return http.StatusBadRequest, codeInvalidChange
+	case errors.Is(err, provider.ErrRecordNotFound):
+		return http.StatusConflict, codeRecordNotFound
+	case errors.Is(err, provider.ErrPaused):
+		return http.StatusServiceUnavailable, codePaused
+	case errors.Is(err, provider.ErrTooManyDeletions):
+		return http.StatusUnprocessableEntity, codeTooManyDeletions
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout, codeBackendTimeout
+	case errors.As(err, &transportErr):
+		return http.StatusServiceUnavailable, codeBackendUnavailable
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized, apiErr.StatusCode == http.StatusForbidden:
+			return http.StatusBadGateway, codeBackendUnauthorized
+		case apiErr.StatusCode >= 500:
+			return http.StatusBadGateway, codeBackendError
+		default:
+			return http.StatusUnprocessableEntity, codeBackendRejected
+		}
+	default:
+		return http.StatusInternalServerError, codeInternalError
+	}
+}
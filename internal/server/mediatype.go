@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	webhookMediaType = "application/external.dns.webhook+json"
+	webhookVersion   = "1"
+)
+
+// acceptable reports whether a response in the webhook media type satisfies
+// the given Accept header, as described in RFC 9110 section 12.5.1.
+// The most specific range matching the webhook media type decides its
+// quality, so that ranges with a quality of zero are explicitly refused
+// even when a wildcard accepts anything.
+func acceptable(accept string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	specificity, quality := -1, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		// A range with a version is more specific than the bare type
+		s := -1
+		switch mediaType {
+		case "*/*":
+			s = 0
+		case "application/*":
+			s = 1
+		case webhookMediaType:
+			if version, ok := params["version"]; !ok {
+				s = 2
+			} else if version == webhookVersion {
+				s = 3
+			}
+		}
+		if s > specificity {
+			specificity, quality = s, q
+		}
+	}
+
+	return quality > 0
+}
+
+// checkContentType verifies that a request body is in a media type we can
+// decode: the webhook media type with a supported version, or plain JSON
+func checkContentType(contentType string) error {
+	if contentType == "" {
+		return fmt.Errorf("missing Content-Type, expected %s", mediaTypeFormat)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid Content-Type %q: %w", contentType, err)
+	}
+
+	switch mediaType {
+	case "application/json":
+		return nil
+	case webhookMediaType:
+		if version, ok := params["version"]; ok && version != webhookVersion {
+			return fmt.Errorf("unsupported webhook version %q, expected %s", version, webhookVersion)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported Content-Type %q, expected %s", mediaType, mediaTypeFormat)
+	}
+}
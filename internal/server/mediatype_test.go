@@ -0,0 +1,56 @@
+package server
+
+import "testing"
+
+func TestAcceptable(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected bool
+	}{
+		{"", true},
+		{"*/*", true},
+		{"application/*", true},
+		{"application/external.dns.webhook+json;version=1", true},
+		{"application/external.dns.webhook+json; version=1", true},
+		{"application/external.dns.webhook+json", true},
+		{"application/external.dns.webhook+json;version=1, */*;q=0.1", true},
+		{"text/html, application/external.dns.webhook+json;version=1;q=0.9", true},
+		{"application/external.dns.webhook+json;version=2", false},
+		{"application/external.dns.webhook+json;version=1;q=0", false},
+		{"text/html", false},
+		{"*/*;q=0", false},
+		{"application/external.dns.webhook+json;q=0, */*", false},
+		{"application/*;q=0, */*", false},
+		{"application/external.dns.webhook+json;version=1, application/external.dns.webhook+json;q=0", true},
+		{"*/*;q=0, application/external.dns.webhook+json", true},
+	}
+
+	for _, tt := range tests {
+		if result := acceptable(tt.accept); result != tt.expected {
+			t.Errorf("acceptable(%q) = %v, expected %v", tt.accept, result, tt.expected)
+		}
+	}
+}
+
+func TestCheckContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		ok          bool
+	}{
+		{"application/external.dns.webhook+json;version=1", true},
+		{"application/external.dns.webhook+json", true},
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"application/external.dns.webhook+json;version=2", false},
+		{"text/plain", false},
+		{"", false},
+		{"not a media type;;", false},
+	}
+
+	for _, tt := range tests {
+		err := checkContentType(tt.contentType)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkContentType(%q) = %v, expected ok=%v", tt.contentType, err, tt.ok)
+		}
+	}
+}
@@ -1,33 +1,173 @@
 package server
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/auth"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/dashboard"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/requestid"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/snapshot"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/tracing"
 	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
 )
 
 const (
 	mediaTypeFormat = "application/external.dns.webhook+json;version=1"
+
+	// defaultMaxBodyBytes is the default limit on request bodies
+	defaultMaxBodyBytes = 1 << 20
 )
 
 // Server implements the external-dns webhook HTTP server
 type Server struct {
-	provider   *provider.Provider
-	port       int
-	healthPort int
+	provider    *provider.Provider
+	port        int
+	healthPort  int
+	bindAddress string
+	tlsConfig   *tls.Config
+	auth        auth.Authenticator
+
+	policy atomic.Pointer[Policy]
+
+	adminBindAddress string
+	adminPort        int
+	adminAuth        auth.Authenticator
+	events           http.Handler
+	snapshots        *snapshot.Store
+
+	dashboardBindAddress string
+	dashboardPort        int
+	dashboardTLSConfig   *tls.Config
+
+	ready   func() error
+	metrics http.Handler
+}
+
+// Policy holds the request handling settings, which can be changed at
+// runtime with SetPolicy
+type Policy struct {
+	// MaxBodyBytes limits the size of request bodies
+	MaxBodyBytes int64
+	// StrictDecoding rejects request bodies containing unknown fields
+	StrictDecoding bool
+}
+
+// Option configures optional server behavior
+type Option func(*Server)
+
+// WithBindAddress sets the address the API server listens on.
+// The health server always listens on all interfaces.
+func WithBindAddress(address string) Option {
+	return func(s *Server) {
+		s.bindAddress = address
+	}
+}
+
+// WithTLS serves the API over TLS using the given configuration
+func WithTLS(config *tls.Config) Option {
+	return func(s *Server) {
+		s.tlsConfig = config
+	}
+}
+
+// WithAuthenticator requires requests to the API server to be
+// authenticated. The health server stays unauthenticated.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(s *Server) {
+		s.auth = authenticator
+	}
+}
+
+// WithMaxBodyBytes limits the size of request bodies
+func WithMaxBodyBytes(n int64) Option {
+	return func(s *Server) {
+		policy := s.Policy()
+		policy.MaxBodyBytes = n
+		s.SetPolicy(policy)
+	}
+}
+
+// WithStrictDecoding rejects request bodies containing unknown fields
+func WithStrictDecoding(strict bool) Option {
+	return func(s *Server) {
+		policy := s.Policy()
+		policy.StrictDecoding = strict
+		s.SetPolicy(policy)
+	}
+}
+
+// WithDashboard serves the read-only web dashboard on a separate listener
+func WithDashboard(bindAddress string, port int) Option {
+	return func(s *Server) {
+		s.dashboardBindAddress = bindAddress
+		s.dashboardPort = port
+	}
+}
+
+// WithDashboardTLS serves the dashboard over TLS using the given
+// configuration. The dashboard does not use the configuration of the API,
+// whose client certificates are meant for external-dns rather than
+// browsers.
+func WithDashboardTLS(config *tls.Config) Option {
+	return func(s *Server) {
+		s.dashboardTLSConfig = config
+	}
+}
+
+// WithReadiness makes /readyz answer 503 while ready returns an error.
+// /healthz and /livez are not affected.
+func WithReadiness(ready func() error) Option {
+	return func(s *Server) {
+		s.ready = ready
+	}
+}
+
+// WithMetrics serves handler on /metrics of the health server
+func WithMetrics(handler http.Handler) Option {
+	return func(s *Server) {
+		s.metrics = handler
+	}
 }
 
 // NewServer creates a new webhook server
-func NewServer(provider *provider.Provider, port, healthPort int) *Server {
-	return &Server{
+func NewServer(provider *provider.Provider, port, healthPort int, opts ...Option) *Server {
+	s := &Server{
 		provider:   provider,
 		port:       port,
 		healthPort: healthPort,
 	}
+	s.policy.Store(&Policy{MaxBodyBytes: defaultMaxBodyBytes})
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Policy returns the request handling settings
+func (s *Server) Policy() Policy {
+	return *s.policy.Load()
+}
+
+// SetPolicy replaces the request handling settings. Requests being
+// handled keep the previous ones.
+func (s *Server) SetPolicy(policy Policy) {
+	s.policy.Store(&policy)
 }
 
 // Start starts the HTTP server
@@ -39,22 +179,70 @@ func (s *Server) Start() error {
 		}
 	}()
 
+	// Start admin server in a goroutine, when enabled
+	if s.adminPort > 0 {
+		go func() {
+			if err := s.startAdminServer(); err != nil {
+				log.Fatalf("Admin server failed: %v", err)
+			}
+		}()
+	}
+
+	// Start dashboard server in a goroutine, when enabled
+	if s.dashboardPort > 0 {
+		go func() {
+			if err := s.startDashboardServer(); err != nil {
+				log.Fatalf("Dashboard server failed: %v", err)
+			}
+		}()
+	}
+
 	// Start main API server
 	return s.startAPIServer()
 }
 
 // startAPIServer starts the main API server
 func (s *Server) startAPIServer() error {
+	addr := net.JoinHostPort(s.bindAddress, strconv.Itoa(s.port))
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   s.apiHandler(),
+		TLSConfig: s.tlsConfig,
+	}
+
+	if s.tlsConfig != nil {
+		log.Printf("Starting API server on %s (TLS)", addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+
+	log.Printf("Starting API server on %s", addr)
+	return srv.ListenAndServe()
+}
+
+// apiHandler returns the handler of the provider endpoints, with its
+// middlewares
+func (s *Server) apiHandler() http.Handler {
 	mux := http.NewServeMux()
 
 	// Provider endpoints
-	mux.HandleFunc("/", s.negotiate)
-	mux.HandleFunc("/records", s.handleRecords)
-	mux.HandleFunc("/adjustendpoints", s.adjustEndpoints)
+	mux.Handle("/", s.tracingMiddleware("/", http.HandlerFunc(s.negotiate)))
+	mux.Handle("/records", s.tracingMiddleware("/records", http.HandlerFunc(s.handleRecords)))
+	mux.Handle("/adjustendpoints", s.tracingMiddleware("/adjustendpoints", http.HandlerFunc(s.adjustEndpoints)))
+
+	var handler http.Handler = mux
+	if s.auth != nil {
+		handler = auth.Middleware(s.auth, handler, func(w http.ResponseWriter, r *http.Request, err error) {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				w.Header().Del("WWW-Authenticate")
+				writePayloadTooLarge(w, r, maxBytesErr.Limit)
+				return
+			}
+			writeError(w, r, http.StatusUnauthorized, errorResponse{Code: codeUnauthorized, Message: "Unauthorized"})
+		})
+	}
 
-	addr := fmt.Sprintf(":%d", s.port)
-	log.Printf("Starting API server on %s", addr)
-	return http.ListenAndServe(addr, s.loggingMiddleware(mux))
+	return requestid.Middleware(s.loggingMiddleware(s.limitBodyMiddleware(handler)))
 }
 
 // startHealthServer starts the health check server
@@ -63,31 +251,114 @@ func (s *Server) startHealthServer() error {
 
 	// Health endpoint
 	mux.HandleFunc("/healthz", s.healthz)
-	mux.HandleFunc("/readyz", s.healthz)
+	mux.HandleFunc("/readyz", s.readyz)
 	mux.HandleFunc("/livez", s.healthz)
+	if s.metrics != nil {
+		mux.Handle("/metrics", s.metrics)
+	}
 
 	addr := fmt.Sprintf(":%d", s.healthPort)
 	log.Printf("Starting health server on %s", addr)
 	return http.ListenAndServe(addr, s.loggingMiddleware(mux))
 }
 
+// startDashboardServer starts the web dashboard server
+func (s *Server) startDashboardServer() error {
+	addr := net.JoinHostPort(s.dashboardBindAddress, strconv.Itoa(s.dashboardPort))
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   s.loggingMiddleware(dashboard.New(s.provider, s.ready)),
+		TLSConfig: s.dashboardTLSConfig,
+	}
+
+	if s.dashboardTLSConfig != nil {
+		log.Printf("Starting dashboard server on %s (TLS)", addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+
+	log.Printf("Starting dashboard server on %s", addr)
+	return srv.ListenAndServe()
+}
+
+// caller identifies the sender of a request in the audit log: its remote
+// address, prefixed with its authenticated subject if any, since the
+// subject only tells which credentials were used
+func caller(r *http.Request) string {
+	if subject := auth.SubjectFromContext(r.Context()); subject != "" {
+		return subject + "@" + r.RemoteAddr
+	}
+	return r.RemoteAddr
+}
+
+// limitBodyMiddleware bounds the size of request bodies, so that
+// authenticators reading the body cannot be fed unbounded data. Bodies
+// announced as too large are refused before authentication.
+func (s *Server) limitBodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.Policy().MaxBodyBytes
+		if r.ContentLength > limit {
+			writePayloadTooLarge(w, r, limit)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s", r.Method, r.URL.Path, r.RemoteAddr)
+		if id := requestid.FromContext(r.Context()); id != "" {
+			log.Printf("%s %s %s request_id=%s", r.Method, r.URL.Path, r.RemoteAddr, id)
+		} else {
+			log.Printf("%s %s %s", r.Method, r.URL.Path, r.RemoteAddr)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// tracingMiddleware starts a server span for each request on route,
+// continuing the trace propagated by the caller if any
+func (s *Server) tracingMiddleware(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
 func (s *Server) negotiate(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errorResponse{Code: codeMethodNotAllowed, Message: "Method not allowed"})
 		return
 	}
 
-	// Check Accept header
-	accept := r.Header.Get("Accept")
-	if accept != "" && accept != mediaTypeFormat && accept != "*/*" {
-		http.Error(w, "Not acceptable", http.StatusNotAcceptable)
+	if !s.checkAccept(w, r) {
 		return
 	}
 
@@ -108,15 +379,20 @@ func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
 	case http.MethodPost:
 		s.applyChanges(w, r)
 	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errorResponse{Code: codeMethodNotAllowed, Message: "Method not allowed"})
 	}
 }
 
 func (s *Server) getRecords(w http.ResponseWriter, r *http.Request) {
-	endpoints, err := s.provider.GetRecords()
+	if !s.checkAccept(w, r) {
+		return
+	}
+
+	endpoints, err := s.provider.GetRecords(r.Context())
 	if err != nil {
 		log.Printf("Failed to get records: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to get records: %v", err), http.StatusInternalServerError)
+		status, code := classifyError(err)
+		writeError(w, r, status, errorResponse{Code: code, Message: fmt.Sprintf("Failed to get records: %v", err)})
 		return
 	}
 
@@ -131,39 +407,94 @@ func (s *Server) getRecords(w http.ResponseWriter, r *http.Request) {
 func (s *Server) applyChanges(w http.ResponseWriter, r *http.Request) {
 	var changes webhook.Changes
 
-	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
-		log.Printf("Failed to decode changes: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to decode changes: %v", err), http.StatusBadRequest)
+	if !s.decodeBody(w, r, &changes) {
+		return
+	}
+
+	if problems := changes.Validate(); len(problems) > 0 {
+		log.Printf("Rejected invalid changes: %s", strings.Join(problems, "; "))
+		writeError(w, r, http.StatusBadRequest, errorResponse{Code: codeValidationFailed, Message: "Invalid changes", Details: problems})
+		return
+	}
+
+	if dryRunRequested(r) {
+		s.planChanges(w, r, &changes)
 		return
 	}
 
-	if err := s.provider.ApplyChanges(&changes); err != nil {
+	results, err := s.provider.ApplyChanges(provider.WithCaller(r.Context(), caller(r)), &changes)
+	if err != nil {
 		log.Printf("Failed to apply changes: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to apply changes: %v", err), http.StatusInternalServerError)
+		status, code := classifyError(err)
+		writeError(w, r, status, errorResponse{
+			Code:    code,
+			Message: fmt.Sprintf("Failed to apply changes: %v", err),
+			Results: results,
+		})
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// DryRunHeader asks POST /records to return the planned operations rather
+// than applying them, as does the dryRun query parameter
+const DryRunHeader = "X-Dry-Run"
+
+// dryRunRequested reports whether the request only asks for a plan
+func dryRunRequested(r *http.Request) bool {
+	value := r.Header.Get(DryRunHeader)
+	if value == "" {
+		value = r.URL.Query().Get("dryRun")
+	}
+	dryRun, _ := strconv.ParseBool(value)
+	return dryRun
+}
+
+// planResponse lists what applying changes would do
+type planResponse struct {
+	DryRun     bool                        `json:"dryRun"`
+	Operations []provider.PlannedOperation `json:"operations"`
+}
+
+func (s *Server) planChanges(w http.ResponseWriter, r *http.Request, changes *webhook.Changes) {
+	operations, err := s.provider.Plan(r.Context(), changes)
+	if err != nil {
+		status, code := classifyError(err)
+		writeError(w, r, status, errorResponse{Code: code, Message: fmt.Sprintf("Failed to plan changes: %v", err)})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, planResponse{DryRun: true, Operations: operations})
+}
+
 func (s *Server) adjustEndpoints(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, errorResponse{Code: codeMethodNotAllowed, Message: "Method not allowed"})
+		return
+	}
+
+	if !s.checkAccept(w, r) {
 		return
 	}
 
 	var endpoints []*webhook.Endpoint
 
-	if err := json.NewDecoder(r.Body).Decode(&endpoints); err != nil {
-		log.Printf("Failed to decode endpoints: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to decode endpoints: %v", err), http.StatusBadRequest)
+	if !s.decodeBody(w, r, &endpoints) {
+		return
+	}
+
+	if problems := webhook.ValidateEndpoints(endpoints); len(problems) > 0 {
+		log.Printf("Rejected invalid endpoints: %s", strings.Join(problems, "; "))
+		writeError(w, r, http.StatusBadRequest, errorResponse{Code: codeValidationFailed, Message: "Invalid endpoints", Details: problems})
 		return
 	}
 
 	adjusted, err := s.provider.AdjustEndpoints(endpoints)
 	if err != nil {
 		log.Printf("Failed to adjust endpoints: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to adjust endpoints: %v", err), http.StatusInternalServerError)
+		status, code := classifyError(err)
+		writeError(w, r, status, errorResponse{Code: code, Message: fmt.Sprintf("Failed to adjust endpoints: %v", err)})
 		return
 	}
 
@@ -175,6 +506,52 @@ func (s *Server) adjustEndpoints(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkAccept verifies that the client accepts the webhook media type,
+// and writes a 406 response otherwise
+func (s *Server) checkAccept(w http.ResponseWriter, r *http.Request) bool {
+	if !acceptable(r.Header.Get("Accept")) {
+		writeError(w, r, http.StatusNotAcceptable, errorResponse{
+			Code:    codeNotAcceptable,
+			Message: fmt.Sprintf("Not acceptable, this server only produces %s", mediaTypeFormat),
+		})
+		return false
+	}
+	return true
+}
+
+// decodeBody checks the Content-Type of the request and decodes its
+// size-limited JSON body into v. On failure, it writes the error response
+// and returns false.
+func (s *Server) decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := checkContentType(r.Header.Get("Content-Type")); err != nil {
+		writeError(w, r, http.StatusUnsupportedMediaType, errorResponse{Code: codeUnsupportedMediaType, Message: err.Error()})
+		return false
+	}
+
+	policy := s.Policy()
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, policy.MaxBodyBytes))
+	if policy.StrictDecoding {
+		decoder.DisallowUnknownFields()
+	}
+
+	err := decoder.Decode(v)
+	if err == nil && policy.StrictDecoding && decoder.More() {
+		err = fmt.Errorf("unexpected data after the JSON document")
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writePayloadTooLarge(w, r, maxBytesErr.Limit)
+			return false
+		}
+		log.Printf("Failed to decode request body: %v", err)
+		writeError(w, r, http.StatusBadRequest, errorResponse{Code: codeInvalidRequest, Message: fmt.Sprintf("Failed to decode request body: %v", err)})
+		return false
+	}
+
+	return true
+}
+
 func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -184,3 +561,15 @@ func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
+
+// readyz answers like healthz, or 503 with the reason while the readiness
+// check fails
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.ready != nil && r.Method == http.MethodGet {
+		if err := s.ready(); err != nil {
+			http.Error(w, "Not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	s.healthz(w, r)
+}
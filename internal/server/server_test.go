@@ -0,0 +1,296 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/auth"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/requestid"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// newTestServer returns a server backed by a fake usg-dns-api that accepts
+// the creation of ok.example.com only
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/records":
+			w.Write([]byte(`[]`))
+		case r.Method == http.MethodPost && r.URL.Path == "/records":
+			var payload map[string]string
+			json.NewDecoder(r.Body).Decode(&payload)
+			if payload["name"] != "ok.example.com" {
+				http.Error(w, "boom", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"1","name":"ok.example.com","target":"1.2.3.4"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(gateway.Close)
+
+	client := usgdns.NewClient(gateway.URL, "test-token")
+	return NewServer(provider.NewProvider(client, nil, false), 0, 0)
+}
+
+func TestApplyChangesPartialFailure(t *testing.T) {
+	s := newTestServer(t)
+
+	body := `{"create":[
+		{"dnsName":"ok.example.com","targets":["1.2.3.4"]},
+		{"dnsName":"ko.example.com","targets":["1.2.3.5"]},
+		{"dnsName":"later.example.com","targets":["1.2.3.6"]}
+	]}`
+	req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(body))
+	req.Header.Set("Content-Type", mediaTypeFormat)
+	req = req.WithContext(requestid.WithID(req.Context(), "req-1"))
+	rec := httptest.NewRecorder()
+
+	s.handleRecords(rec, req)
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("Expected 502, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+
+	if resp.Code != codeBackendError {
+		t.Errorf("Expected code %s, got %s", codeBackendError, resp.Code)
+	}
+	if resp.RequestID != "req-1" {
+		t.Errorf("Expected request ID req-1, got %q", resp.RequestID)
+	}
+
+	expected := []provider.ChangeStatus{provider.StatusApplied, provider.StatusFailed, provider.StatusSkipped}
+	if len(resp.Results) != len(expected) {
+		t.Fatalf("Expected %d results, got %d", len(expected), len(resp.Results))
+	}
+	for i, status := range expected {
+		if resp.Results[i].Status != status {
+			t.Errorf("Result %d (%s): expected %s, got %s", i, resp.Results[i].DNSName, status, resp.Results[i].Status)
+		}
+	}
+}
+
+func TestApplyChangesValidation(t *testing.T) {
+	s := newTestServer(t)
+
+	body := `{"create":[{"dnsName":"","targets":["not-an-ip"]}],"updateOld":[{"dnsName":"a.example.com"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+
+	s.handleRecords(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", rec.Code)
+	}
+
+	var resp errorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+
+	// Empty name, invalid target and mismatched updates
+	if len(resp.Details) != 3 {
+		t.Errorf("Expected 3 problems, got %d: %v", len(resp.Details), resp.Details)
+	}
+}
+
+func TestApplyChangesDryRun(t *testing.T) {
+	s := newTestServer(t)
+
+	// The gateway would reject ko.example.com if it were sent
+	body := `{"create":[{"dnsName":"ko.example.com","targets":["1.2.3.4","1.2.3.5"]}],
+		"updateOld":[{"dnsName":"missing.example.com","targets":["1.2.3.6"]}],
+		"updateNew":[{"dnsName":"missing.example.com","targets":["1.2.3.7"]}]}`
+	req := httptest.NewRequest(http.MethodPost, "/records?dryRun=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", mediaTypeFormat)
+	rec := httptest.NewRecorder()
+
+	s.handleRecords(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp planResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode plan: %v", err)
+	}
+	if len(resp.Operations) != 2 {
+		t.Fatalf("Expected 2 operations, got %d", len(resp.Operations))
+	}
+
+	create := resp.Operations[0]
+	if create.Outcome != provider.OutcomeApply || create.Method != http.MethodPost || create.Target != "1.2.3.4" {
+		t.Errorf("Unexpected create: %+v", create)
+	}
+	if len(create.DroppedTargets) != 1 || create.DroppedTargets[0] != "1.2.3.5" {
+		t.Errorf("Expected 1.2.3.5 to be dropped, got %v", create.DroppedTargets)
+	}
+	if update := resp.Operations[1]; update.Outcome != provider.OutcomeFail {
+		t.Errorf("Expected update of a missing record to fail, got %+v", update)
+	}
+}
+
+func TestSetPolicy(t *testing.T) {
+	s := newTestServer(t)
+
+	post := func() int {
+		body := `{"create":[{"dnsName":"ok.example.com","targets":["1.2.3.4"]}],"unknown":true}`
+		req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(body))
+		req.Header.Set("Content-Type", mediaTypeFormat)
+		rec := httptest.NewRecorder()
+		s.handleRecords(rec, req)
+		return rec.Code
+	}
+
+	if code := post(); code != http.StatusNoContent {
+		t.Fatalf("Expected unknown fields to be accepted, got %d", code)
+	}
+
+	s.SetPolicy(Policy{MaxBodyBytes: defaultMaxBodyBytes, StrictDecoding: true})
+	if code := post(); code != http.StatusBadRequest {
+		t.Errorf("Expected unknown fields to be rejected after the policy changed, got %d", code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	ready := error(nil)
+	s := newTestServer(t)
+	WithReadiness(func() error { return ready })(s)
+
+	get := func(handler http.HandlerFunc) int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	if code := get(s.readyz); code != http.StatusOK {
+		t.Errorf("Expected ready, got %d", code)
+	}
+
+	ready = usgdns.ErrCircuitOpen
+	if code := get(s.readyz); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while the circuit breaker is open, got %d", code)
+	}
+	if code := get(s.healthz); code != http.StatusOK {
+		t.Errorf("Expected liveness to be unaffected, got %d", code)
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tracerProvider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	handler := newTestServer(t).apiHandler()
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/", ""},
+		{http.MethodGet, "/records", ""},
+		{http.MethodPost, "/records", `{}`},
+		{http.MethodPost, "/adjustendpoints", `[]`},
+	}
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		req.Header.Set("Accept", mediaTypeFormat)
+		req.Header.Set("Content-Type", mediaTypeFormat)
+		req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	var names []string
+	for _, span := range recorder.Ended() {
+		if span.SpanKind() != trace.SpanKindServer {
+			continue
+		}
+		names = append(names, span.Name())
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("Expected %s to continue the trace of the caller, got %s", span.Name(), span.SpanContext().TraceID())
+		}
+	}
+	want := []string{"GET /", "GET /records", "POST /records", "POST /adjustendpoints"}
+	if !slices.Equal(names, want) {
+		t.Errorf("Expected server spans %v, got %v", want, names)
+	}
+}
+
+func TestBodyLimitBeforeAuthentication(t *testing.T) {
+	s := newTestServer(t)
+	WithAuthenticator(auth.NewHMAC("secret", time.Minute))(s)
+	s.SetPolicy(Policy{MaxBodyBytes: 16})
+	handler := s.apiHandler()
+
+	body := `{"create":[{"dnsName":"ok.example.com","targets":["1.2.3.4"]}]}`
+	post := func(contentLength int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(body))
+		req.ContentLength = contentLength
+		req.Header.Set("Content-Type", mediaTypeFormat)
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(auth.TimestampHeader, timestamp)
+		req.Header.Set(auth.SignatureHeader, auth.Sign([]byte("secret"), timestamp, req.Method, req.URL.RequestURI(), []byte(body)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Announced, then streamed without a length
+	for _, contentLength := range []int64{int64(len(body)), -1} {
+		rec := post(contentLength)
+		if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), codePayloadTooLarge) {
+			t.Errorf("Content-Length %d: expected 413, got %d: %s", contentLength, rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("WWW-Authenticate") != "" {
+			t.Errorf("Content-Length %d: expected no authentication challenge", contentLength)
+		}
+	}
+}
+
+func TestApplyChangesCaller(t *testing.T) {
+	s := newTestServer(t)
+	WithAuthenticator(auth.NewBearerToken("token"))(s)
+
+	req := httptest.NewRequest(http.MethodPost, "/records", strings.NewReader(`{"create":[{"dnsName":"ok.example.com","targets":["1.2.3.4"]}]}`))
+	req.RemoteAddr = "10.0.3.7:51234"
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Content-Type", mediaTypeFormat)
+	rec := httptest.NewRecorder()
+	s.apiHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if history := s.provider.History(); len(history) != 1 || history[0].Caller != "bearer-token@10.0.3.7:51234" {
+		t.Errorf("Expected the caller to carry the subject and the address, got %+v", history)
+	}
+}
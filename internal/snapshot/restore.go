@@ -0,0 +1,35 @@
+package snapshot
+
+import (
+	"context"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/diff"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/provider"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/webhook"
+)
+
+// RestorePlan returns the changes bringing the live records within the
+// domain filter of prov back to their state in snapshot. Records outside
+// of the domain filter are left alone.
+func RestorePlan(ctx context.Context, prov *provider.Provider, snapshot *Snapshot) (*webhook.Changes, error) {
+	records, err := prov.RefreshCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	current := diff.Filter(provider.Endpoints(records), prov.Manages)
+	desired := diff.Filter(provider.Endpoints(snapshot.Records), prov.Manages)
+	return diff.Compute(current, desired), nil
+}
+
+// Restore brings the live records back to their state in snapshot. In
+// dry-run mode, the changes are only computed.
+func Restore(ctx context.Context, prov *provider.Provider, snapshot *Snapshot, dryRun bool) (*webhook.Changes, []provider.ChangeResult, error) {
+	changes, err := RestorePlan(ctx, prov, snapshot)
+	if err != nil || dryRun || changes.IsEmpty() {
+		return changes, nil, err
+	}
+
+	results, err := prov.ApplyChanges(ctx, changes)
+	return changes, results, err
+}
@@ -0,0 +1,227 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+// idFormat names snapshots after the time they were taken, so that they
+// sort chronologically
+const idFormat = "20060102T150405.000000Z"
+
+// validID guards against path traversal through snapshot IDs
+var validID = regexp.MustCompile(`^[0-9]{8}T[0-9]{6}\.[0-9]{6}Z$`)
+
+// ErrNotFound is returned when a snapshot does not exist
+var ErrNotFound = errors.New("snapshot not found")
+
+// Snapshot is the full inventory of usg-dns-api at a point in time
+type Snapshot struct {
+	ID        string          `json:"id"`
+	TakenAt   time.Time       `json:"takenAt"`
+	RequestID string          `json:"requestId,omitempty"`
+	Records   []usgdns.Record `json:"records"`
+}
+
+// Info describes a snapshot without its records
+type Info struct {
+	ID        string    `json:"id"`
+	TakenAt   time.Time `json:"takenAt"`
+	RequestID string    `json:"requestId,omitempty"`
+	Records   int       `json:"records"`
+}
+
+// Store keeps snapshots as JSON files in a directory. After each new
+// snapshot, those beyond the keep newest or older than maxAge are removed;
+// a zero value disables either limit.
+type Store struct {
+	mu     sync.Mutex
+	dir    string
+	keep   int
+	maxAge time.Duration
+	now    func() time.Time
+}
+
+// NewStore creates the snapshot directory if needed
+func NewStore(dir string, keep int, maxAge time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &Store{
+		dir:    dir,
+		keep:   keep,
+		maxAge: maxAge,
+		now:    time.Now,
+	}, nil
+}
+
+// Snapshot implements provider.Snapshotter
+func (s *Store) Snapshot(_ context.Context, requestID string, records []usgdns.Record) error {
+	snapshot, err := s.Save(requestID, records)
+	if err != nil {
+		return err
+	}
+	log.Printf("Saved snapshot %s of %d records", snapshot.ID, len(records))
+	return nil
+}
+
+// Save writes a new snapshot of records and applies the retention policy
+func (s *Store) Save(requestID string, records []usgdns.Record) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	takenAt := s.now().UTC()
+	snapshot := &Snapshot{
+		ID:        takenAt.Format(idFormat),
+		TakenAt:   takenAt,
+		RequestID: requestID,
+		Records:   records,
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first so that a crash never leaves a
+	// truncated snapshot behind
+	tmp, err := os.CreateTemp(s.dir, ".snapshot-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), s.path(snapshot.ID)); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	if err := s.prune(); err != nil {
+		log.Printf("Failed to remove old snapshots: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// List returns the snapshots, newest first
+func (s *Store) List() ([]Info, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]Info, 0, len(ids))
+	for _, id := range ids {
+		snapshot, err := s.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, Info{
+			ID:        snapshot.ID,
+			TakenAt:   snapshot.TakenAt,
+			RequestID: snapshot.RequestID,
+			Records:   len(snapshot.Records),
+		})
+	}
+	return infos, nil
+}
+
+// Load reads a snapshot. The ID "latest" designates the newest one.
+func (s *Store) Load(id string) (*Snapshot, error) {
+	if id == "latest" {
+		ids, err := s.ids()
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, ErrNotFound
+		}
+		id = ids[0]
+	}
+
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	data, err := os.ReadFile(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", id, err)
+	}
+	return &snapshot, nil
+}
+
+// prune removes the snapshots beyond the retention limits. The newest
+// snapshot is always kept.
+func (s *Store) prune() error {
+	ids, err := s.ids()
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	for i, id := range ids {
+		if i == 0 {
+			continue
+		}
+		expired := s.keep > 0 && i >= s.keep
+		if !expired && s.maxAge > 0 {
+			takenAt, err := time.Parse(idFormat, id)
+			expired = err == nil && now.Sub(takenAt) > s.maxAge
+		}
+		if expired {
+			if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ids returns the IDs of the snapshots, newest first
+func (s *Store) ids() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if ok && validID.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
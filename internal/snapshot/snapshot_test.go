@@ -0,0 +1,61 @@
+package snapshot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/usgdns"
+)
+
+func TestStoreRetention(t *testing.T) {
+	store, err := NewStore(t.TempDir(), 3, 48*time.Hour)
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	records := []usgdns.Record{{ID: "1", Name: "a.example.com", Target: "1.2.3.4"}}
+	save := func() *Snapshot {
+		t.Helper()
+		snapshot, err := store.Save("req", records)
+		if err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		return snapshot
+	}
+
+	// Too old once the last one is taken
+	save()
+	now = now.Add(72 * time.Hour)
+	for i := 0; i < 4; i++ {
+		save()
+		now = now.Add(time.Minute)
+	}
+	last := save()
+
+	infos, err := store.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("Expected 3 snapshots to be kept, got %d", len(infos))
+	}
+	if infos[0].ID != last.ID || infos[0].Records != 1 {
+		t.Errorf("Expected newest snapshot first, got %+v", infos[0])
+	}
+
+	latest, err := store.Load("latest")
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if latest.ID != last.ID || len(latest.Records) != 1 || latest.Records[0].Target != "1.2.3.4" {
+		t.Errorf("Unexpected latest snapshot: %+v", latest)
+	}
+
+	if _, err := store.Load("../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected invalid ID to be rejected, got %v", err)
+	}
+}
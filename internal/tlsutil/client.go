@@ -0,0 +1,235 @@
+package tlsutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/filewatch"
+)
+
+// pinPrefix prefixes SPKI pins, as in HTTP Public Key Pinning
+const pinPrefix = "sha256/"
+
+// ErrPinMismatch is returned when no certificate of the server chain
+// matches the pins
+var ErrPinMismatch = errors.New("no certificate of the server chain matches the pins")
+
+// ClientOptions are the TLS settings of a client
+type ClientOptions struct {
+	// CAFile is a PEM bundle of the CAs trusted instead of the system ones
+	CAFile string
+	// CertFile and KeyFile are the client certificate presented for mTLS
+	CertFile string
+	KeyFile  string
+	// ServerName is the name the server certificate is verified against
+	ServerName string
+	// InsecureSkipVerify disables the verification of the server
+	// certificate chain and name. Pins are still checked,
+	// against the server certificate only.
+	InsecureSkipVerify bool
+	// Pins are sha256/BASE64 hashes of the SubjectPublicKeyInfo of a
+	// certificate of the server chain. One of them must match.
+	Pins []string
+}
+
+// ClientConfig provides a client TLS configuration whose CA bundle and
+// client certificate are reloaded from disk when the files change
+type ClientConfig struct {
+	opts ClientOptions
+	pins [][]byte
+
+	mu    sync.RWMutex
+	roots *x509.CertPool
+	cert  *tls.Certificate
+}
+
+// NewClientConfig parses the pins and loads the files of opts
+func NewClientConfig(opts ClientOptions) (*ClientConfig, error) {
+	c := &ClientConfig{opts: opts}
+
+	for _, pin := range opts.Pins {
+		hash, err := ParsePin(pin)
+		if err != nil {
+			return nil, err
+		}
+		c.pins = append(c.pins, hash)
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ParsePin decodes a sha256/BASE64 SPKI pin
+func ParsePin(pin string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(pin, pinPrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid pin %q: must start with %s", pin, pinPrefix)
+	}
+	hash, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("invalid pin %q: expected the base64 of a SHA-256 hash", pin)
+	}
+	return hash, nil
+}
+
+// Pin returns the sha256/BASE64 SPKI pin of a certificate
+func Pin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// Reload reads the files again. On error, the previous configuration is kept.
+func (c *ClientConfig) Reload() error {
+	var roots *x509.CertPool
+	if c.opts.CAFile != "" {
+		var err error
+		if roots, err = LoadCertPool(c.opts.CAFile); err != nil {
+			return err
+		}
+	}
+
+	var cert *tls.Certificate
+	if c.opts.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(c.opts.CertFile, c.opts.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cert = &pair
+	}
+
+	c.mu.Lock()
+	c.roots = roots
+	c.cert = cert
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Watch reloads the configuration whenever one of the files changes. It
+// blocks until ctx is done, and returns immediately without files.
+func (c *ClientConfig) Watch(ctx context.Context, interval time.Duration) {
+	var paths []string
+	for _, path := range []string{c.opts.CAFile, c.opts.CertFile, c.opts.KeyFile} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+
+	filewatch.Watch(ctx, interval, func() {
+		if err := c.Reload(); err != nil {
+			log.Printf("Failed to reload client TLS configuration, keeping the previous one: %v", err)
+			return
+		}
+		log.Printf("Reloaded client TLS configuration")
+	}, paths...)
+}
+
+// TLSConfig returns a tls.Config always using the latest loaded files.
+// The standard verification is replaced by verifyConnection, as the
+// trusted CAs of a tls.Config cannot change once in use.
+func (c *ClientConfig) TLSConfig() *tls.Config {
+	return c.TLSConfigFor("")
+}
+
+// TLSConfigFor is TLSConfig for a connection to host, the name the server
+// certificate is verified against when ServerName is not set. The
+// handshake leaves out the name of IP hosts, so they must be given this way.
+func (c *ClientConfig) TLSConfigFor(host string) *tls.Config {
+	serverName := c.opts.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return c.verifyConnection(state, serverName)
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+
+			// An empty certificate lets the server decide whether one is
+			// required
+			if c.cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return c.cert, nil
+		},
+	}
+}
+
+// verifyConnection verifies the server certificate chain and name, unless
+// InsecureSkipVerify is set, then the pins
+func (c *ClientConfig) verifyConnection(state tls.ConnectionState, serverName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server sent no certificate")
+	}
+
+	// Pins only match certificates of the verified chains, which can end
+	// with a root the server did not send, so that a CA can be pinned. The
+	// other certificates the server sent prove nothing, so without
+	// verification only its own certificate is matched.
+	candidates := state.PeerCertificates[:1]
+	if !c.opts.InsecureSkipVerify {
+		c.mu.RLock()
+		roots := c.roots
+		c.mu.RUnlock()
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		if serverName == "" {
+			serverName = state.ServerName
+		}
+		if serverName == "" {
+			return errors.New("no server name to verify the certificate against")
+		}
+
+		chains, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			DNSName:       serverName,
+		})
+		if err != nil {
+			return err
+		}
+		candidates = nil
+		for _, chain := range chains {
+			candidates = append(candidates, chain...)
+		}
+	}
+
+	if len(c.pins) == 0 {
+		return nil
+	}
+	for _, cert := range candidates {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range c.pins {
+			if bytes.Equal(hash[:], pin) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w, the server presented %s", ErrPinMismatch, Pin(state.PeerCertificates[0]))
+}
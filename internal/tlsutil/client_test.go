@@ -0,0 +1,158 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newTLSServer starts a server presenting the certificate of certFile,
+// requiring client certificates signed by clientCAs when it is not nil
+func newTLSServer(t *testing.T, certFile, keyFile string, clientCAs *x509.CertPool) *httptest.Server {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if clientCAs != nil {
+		server.TLS.ClientCAs = clientCAs
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// get sends a request to url with the TLS configuration of opts
+func get(t *testing.T, url string, opts ClientOptions) error {
+	t.Helper()
+
+	config, err := NewClientConfig(opts)
+	if err != nil {
+		t.Fatalf("NewClientConfig failed: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config.TLSConfig()}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestClientConfigVerify(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSigned(t, dir, "gateway")
+	server := newTLSServer(t, certFile, keyFile, nil)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := Pin(leaf)
+	otherPin := "sha256/" + strings.Repeat("A", 43) + "="
+
+	tests := []struct {
+		name    string
+		opts    ClientOptions
+		wantErr string
+	}{
+		{"CA bundle", ClientOptions{CAFile: certFile, ServerName: "gateway"}, ""},
+		{"system CAs", ClientOptions{ServerName: "gateway"}, "unknown authority"},
+		{"wrong server name", ClientOptions{CAFile: certFile, ServerName: "other"}, "not other"},
+		{"insecure", ClientOptions{InsecureSkipVerify: true}, ""},
+		{"pin", ClientOptions{CAFile: certFile, ServerName: "gateway", Pins: []string{otherPin, pin}}, ""},
+		{"insecure with pin", ClientOptions{InsecureSkipVerify: true, Pins: []string{pin}}, ""},
+		{"wrong pin", ClientOptions{InsecureSkipVerify: true, Pins: []string{otherPin}}, "matches the pins"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := get(t, server.URL, tt.opts)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Expected request to succeed, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestClientConfigPinsIgnoreUnverified(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSigned(t, dir, "gateway")
+	pinnedFile, _ := writeSelfSigned(t, dir, "pinned")
+
+	// The server appends a pinned certificate unrelated to its own, which
+	// must not satisfy the pins
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinnedPEM, err := os.ReadFile(pinnedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pinnedPEM)
+	cert.Certificate = append(cert.Certificate, block.Bytes)
+	pinnedCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	pin := Pin(pinnedCert)
+	for _, opts := range []ClientOptions{
+		{CAFile: certFile, ServerName: "gateway", Pins: []string{pin}},
+		{InsecureSkipVerify: true, Pins: []string{pin}},
+	} {
+		if err := get(t, server.URL, opts); err == nil || !strings.Contains(err.Error(), "matches the pins") {
+			t.Errorf("Expected a pin mismatch with InsecureSkipVerify=%v, got %v", opts.InsecureSkipVerify, err)
+		}
+	}
+}
+
+func TestClientConfigCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSigned(t, dir, "gateway")
+	clientCert, clientKey := writeSelfSigned(t, dir, "client")
+
+	clientCAs, err := LoadCertPool(clientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTLSServer(t, certFile, keyFile, clientCAs)
+
+	if err := get(t, server.URL, ClientOptions{CAFile: certFile, ServerName: "gateway"}); err == nil {
+		t.Error("Expected request without client certificate to fail")
+	}
+	opts := ClientOptions{CAFile: certFile, ServerName: "gateway", CertFile: clientCert, KeyFile: clientKey}
+	if err := get(t, server.URL, opts); err != nil {
+		t.Errorf("Expected request with client certificate to succeed, got %v", err)
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	for _, pin := range []string{"AAAA", "sha256/not-base64", "sha256/AAAA"} {
+		if _, err := ParsePin(pin); err == nil {
+			t.Errorf("Expected %q to be rejected", pin)
+		}
+	}
+}
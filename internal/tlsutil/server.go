@@ -0,0 +1,119 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/filewatch"
+)
+
+// ServerConfig provides a server TLS configuration whose certificate and
+// client CA bundle are reloaded from disk when the files change
+type ServerConfig struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewServerConfig loads the certificate, key and optional client CA bundle.
+// When clientCAFile is set, clients must present a certificate signed by
+// one of the CAs it contains.
+func NewServerConfig(certFile, keyFile, clientCAFile string) (*ServerConfig, error) {
+	s := &ServerConfig{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reload reads the files again. On error, the previous configuration is kept.
+func (s *ServerConfig) Reload() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if s.clientCAFile != "" {
+		clientCAs, err = LoadCertPool(s.clientCAFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.cert = &cert
+	s.clientCAs = clientCAs
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Watch reloads the configuration whenever one of the files changes.
+// It blocks until ctx is done.
+func (s *ServerConfig) Watch(ctx context.Context, interval time.Duration) {
+	paths := []string{s.certFile, s.keyFile}
+	if s.clientCAFile != "" {
+		paths = append(paths, s.clientCAFile)
+	}
+
+	filewatch.Watch(ctx, interval, func() {
+		if err := s.Reload(); err != nil {
+			log.Printf("Failed to reload server TLS configuration, keeping the previous one: %v", err)
+			return
+		}
+		log.Printf("Reloaded server TLS configuration")
+	}, paths...)
+}
+
+// TLSConfig returns a tls.Config always serving the latest loaded files
+func (s *ServerConfig) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*s.cert},
+			}
+			if s.clientCAs != nil {
+				config.ClientCAs = s.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in CA bundle %s", path)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,138 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned writes a self-signed certificate and its key to dir and
+// returns their paths
+func writeSelfSigned(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestServerConfigReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSigned(t, dir, "first")
+
+	config, err := NewServerConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("NewServerConfig failed: %v", err)
+	}
+
+	serving := func() string {
+		tlsConfig, err := config.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("GetConfigForClient failed: %v", err)
+		}
+		leaf, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatalf("Failed to parse certificate: %v", err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	if cn := serving(); cn != "first" {
+		t.Errorf("Expected first certificate, got %s", cn)
+	}
+
+	// Overwrite the files with a new certificate
+	newCert, newKey := writeSelfSigned(t, dir, "second")
+	if err := os.Rename(newCert, certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(newKey, keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := config.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if cn := serving(); cn != "second" {
+		t.Errorf("Expected second certificate after reload, got %s", cn)
+	}
+
+	// A broken file must keep the previous certificate
+	if err := os.WriteFile(certFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Reload(); err == nil {
+		t.Error("Expected reload of an invalid certificate to fail")
+	}
+	if cn := serving(); cn != "second" {
+		t.Errorf("Expected second certificate to be kept, got %s", cn)
+	}
+}
+
+func TestServerConfigClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeSelfSigned(t, dir, "server")
+	caFile, _ := writeSelfSigned(t, dir, "client")
+
+	config, err := NewServerConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("NewServerConfig failed: %v", err)
+	}
+
+	tlsConfig, err := config.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetConfigForClient failed: %v", err)
+	}
+
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("Expected client certificates to be required, got %v", tlsConfig.ClientAuth)
+	}
+
+	if tlsConfig.ClientCAs == nil {
+		t.Error("Expected client CA pool to be set")
+	}
+}
@@ -0,0 +1,89 @@
+package usgdns
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/auth"
+)
+
+// Authentication modes of the client
+const (
+	// AuthRaw sends the token as is in the Authorization header, as
+	// usg-dns-api expects it
+	AuthRaw = "raw"
+	// AuthBearer sends Authorization: Bearer <token>
+	AuthBearer = "bearer"
+	// AuthBasic sends basic auth credentials, the token being the password
+	AuthBasic = "basic"
+	// AuthHeader sends the token in a custom header
+	AuthHeader = "header"
+	// AuthHMAC signs requests with the token, like the hmac mode of the
+	// webhook API
+	AuthHMAC = "hmac"
+)
+
+// AuthModes lists the supported authentication modes
+var AuthModes = []string{AuthRaw, AuthBearer, AuthBasic, AuthHeader, AuthHMAC}
+
+// Authenticator adds credentials to the requests sent to usg-dns-api. The
+// token is the current one, as it can be rotated, and body is the request
+// body, nil when there is none.
+type Authenticator interface {
+	Authenticate(req *http.Request, body []byte, token string)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface
+type AuthenticatorFunc func(req *http.Request, body []byte, token string)
+
+// Authenticate implements Authenticator
+func (f AuthenticatorFunc) Authenticate(req *http.Request, body []byte, token string) {
+	f(req, body, token)
+}
+
+// WithAuthenticator replaces the default raw token authentication
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(c *Client) {
+		c.auth = authenticator
+	}
+}
+
+// RawToken sends the token as the value of the Authorization header
+func RawToken() Authenticator {
+	return AuthenticatorFunc(func(req *http.Request, _ []byte, token string) {
+		req.Header.Set("Authorization", token)
+	})
+}
+
+// BearerToken sends the token as a bearer token
+func BearerToken() Authenticator {
+	return AuthenticatorFunc(func(req *http.Request, _ []byte, token string) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	})
+}
+
+// BasicAuth sends the username and the token as basic auth credentials
+func BasicAuth(username string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request, _ []byte, token string) {
+		req.SetBasicAuth(username, token)
+	})
+}
+
+// HeaderToken sends the token in the named header
+func HeaderToken(name string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request, _ []byte, token string) {
+		req.Header.Set(name, token)
+	})
+}
+
+// HMACSignature signs requests with the token as the secret. The signature
+// is computed by auth.Sign and sent with the timestamp in the same headers
+// as those the webhook API verifies in hmac mode.
+func HMACSignature() Authenticator {
+	return AuthenticatorFunc(func(req *http.Request, body []byte, token string) {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(auth.TimestampHeader, timestamp)
+		req.Header.Set(auth.SignatureHeader, auth.Sign([]byte(token), timestamp, req.Method, req.URL.RequestURI(), body))
+	})
+}
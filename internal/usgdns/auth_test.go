@@ -0,0 +1,55 @@
+package usgdns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/auth"
+)
+
+func TestAuthenticators(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticator Authenticator
+		check         func(r *http.Request) bool
+	}{
+		{"raw", RawToken(), func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "secret"
+		}},
+		{"bearer", BearerToken(), func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer secret"
+		}},
+		{"basic", BasicAuth("dns"), func(r *http.Request) bool {
+			username, password, ok := r.BasicAuth()
+			return ok && username == "dns" && password == "secret"
+		}},
+		{"header", HeaderToken("X-API-Key"), func(r *http.Request) bool {
+			return r.Header.Get("X-API-Key") == "secret" && r.Header.Get("Authorization") == ""
+		}},
+		{"hmac", HMACSignature(), func(r *http.Request) bool {
+			_, err := auth.NewHMAC("secret", time.Minute).Authenticate(r)
+			return err == nil
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.check(r) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte(`{"id":"1","name":"a.example.com","target":"1.2.3.4"}`))
+			}))
+			defer gateway.Close()
+
+			client := NewClient(gateway.URL, "secret", WithAuthenticator(tt.authenticator))
+			if _, err := client.CreateRecord(context.Background(), "a.example.com", "1.2.3.4"); err != nil {
+				t.Errorf("Expected the request to be authenticated, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package usgdns
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Write modes of a client with several backends
+const (
+	// WritePrimary sends each write to a single backend: the one the record
+	// was read from, or for creates the one serving reads
+	WritePrimary = "primary"
+	// WriteAll also applies each write to every other backend
+	WriteAll = "all"
+)
+
+// WriteModes lists the supported write modes
+var WriteModes = []string{WritePrimary, WriteAll}
+
+// WithFailover adds backends to the one given to NewClient. Reads go to
+// the first available backend in this order, and fail over to the next
+// ones when it cannot be reached or answers with a 5xx status.
+func WithFailover(urls ...string) Option {
+	return func(c *Client) {
+		c.urls = append(c.urls, urls...)
+	}
+}
+
+// WithWriteMode sets how writes are spread across the backends, WritePrimary
+// being the default
+func WithWriteMode(mode string) Option {
+	return func(c *Client) {
+		c.writeMode = mode
+	}
+}
+
+// backend is an instance of usg-dns-api, with its own connections, rate
+// limiter and circuit breaker
+type backend struct {
+	url        string
+	httpClient *http.Client
+	limiter    *rate.Limiter
+	breaker    *breaker
+	// rateLimited counts the requests delayed by the rate limiter
+	rateLimited atomic.Uint64
+	// down is set when the backend last failed, until it answers again
+	down atomic.Bool
+}
+
+func (c *Client) newBackend(baseURL string) *backend {
+	b := &backend{
+		url:        strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: c.timeout},
+	}
+
+	if c.tls != nil {
+		var host string
+		if u, err := url.Parse(b.url); err == nil {
+			host = u.Hostname()
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = c.tls.TLSConfigFor(host)
+		b.httpClient.Transport = transport
+	}
+	if c.rateLimit > 0 {
+		b.limiter = rate.NewLimiter(rate.Limit(c.rateLimit), max(c.rateBurst, 1))
+	}
+	if c.breakerThreshold > 0 {
+		b.breaker = newBreaker(b.url, c.breakerThreshold, c.breakerCooldown)
+	}
+
+	return b
+}
+
+// BackendStats are the state and counters of a backend
+type BackendStats struct {
+	URL string
+	// Active is set on the backend serving reads
+	Active bool
+	// Healthy is unset from a failure of the backend until it answers again
+	Healthy      bool
+	BreakerState BreakerState
+	// BreakerOpened counts the times the breaker opened
+	BreakerOpened uint64
+	// BreakerRejected counts the requests failed by the open breaker
+	BreakerRejected uint64
+	// RateLimited counts the requests which waited for the rate limiter
+	RateLimited uint64
+}
+
+// Stats are the state and counters of the backends
+type Stats struct {
+	Backends []BackendStats
+	// Switches counts the times reads moved to another backend
+	Switches uint64
+}
+
+// Stats returns the current state and counters
+func (c *Client) Stats() Stats {
+	c.mu.Lock()
+	active := c.active
+	c.mu.Unlock()
+
+	stats := Stats{Switches: c.switches.Load()}
+	for i, b := range c.backends {
+		backend := BackendStats{
+			URL:         b.url,
+			Active:      i == active,
+			Healthy:     !b.down.Load(),
+			RateLimited: b.rateLimited.Load(),
+		}
+		if b.breaker != nil {
+			backend.BreakerState, backend.BreakerOpened, backend.BreakerRejected = b.breaker.snapshot()
+		}
+		stats.Backends = append(stats.Backends, backend)
+	}
+	return stats
+}
+
+// Ready returns ErrCircuitOpen while the circuit breakers of all backends
+// are open, so that readiness checks take the process out of rotation
+// until usg-dns-api is expected to answer again
+func (c *Client) Ready() error {
+	for _, b := range c.Stats().Backends {
+		if b.BreakerState != BreakerOpen {
+			return nil
+		}
+	}
+	return ErrCircuitOpen
+}
+
+// WatchBackends sends a request to every backend at each interval, so that
+// reads move back to a backend as soon as it answers again and the health
+// of the backends not serving reads is known. It blocks until ctx is done,
+// and returns immediately with a single backend.
+func (c *Client) WatchBackends(ctx context.Context, interval time.Duration) {
+	if len(c.backends) < 2 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, b := range c.backends {
+				c.observe(b, c.do(ctx, b, http.MethodGet, "/records", nil, nil, http.StatusOK))
+			}
+		}
+	}
+}
+
+// read sends a GET request to the first available backend, then to the
+// next ones while they are unavailable, and returns the backend which
+// answered. Backends known to be down are tried last.
+func (c *Client) read(ctx context.Context, path string, out any) (*backend, error) {
+	var up, down []*backend
+	for _, b := range c.backends {
+		if b.down.Load() {
+			down = append(down, b)
+		} else {
+			up = append(up, b)
+		}
+	}
+
+	var err error
+	for _, b := range append(up, down...) {
+		err = c.do(ctx, b, http.MethodGet, path, nil, out, http.StatusOK)
+		c.observe(b, err)
+		if !unavailable(err) || ctx.Err() != nil {
+			return b, err
+		}
+	}
+	return nil, err
+}
+
+// observe marks a backend down when err shows it is unavailable, and up
+// again when it answers, moving reads to the first backend which is up
+func (c *Client) observe(b *backend, err error) {
+	if len(c.backends) < 2 {
+		return
+	}
+
+	switch {
+	case unavailable(err):
+		if b.down.CompareAndSwap(false, true) {
+			log.Printf("usg-dns-api at %s is unavailable: %v", b.url, err)
+		}
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// The caller gave up, which tells nothing about the backend
+		return
+	default:
+		if b.down.CompareAndSwap(true, false) {
+			log.Printf("usg-dns-api at %s answers again", b.url)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	active := c.active
+	for i, candidate := range c.backends {
+		if !candidate.down.Load() {
+			active = i
+			break
+		}
+	}
+	if active != c.active {
+		log.Printf("Switching usg-dns-api from %s to %s", c.backends[c.active].url, c.backends[active].url)
+		c.active = active
+		c.switches.Add(1)
+	}
+}
+
+// primary returns the backend serving reads
+func (c *Client) primary() *backend {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.backends[c.active]
+}
+
+// backendOf returns the backend a record was read from, or the one
+// serving reads when it is unknown
+func (c *Client) backendOf(record Record) *backend {
+	for _, b := range c.backends {
+		if b.url == record.Backend {
+			return b
+		}
+	}
+	return c.primary()
+}
+
+// mirror applies a write made on origin to the other backends in write
+// mode all. apply is given the records of each backend to find those the
+// write concerns by name and target, as IDs differ from one backend to
+// another.
+func (c *Client) mirror(ctx context.Context, origin *backend, apply func(ctx context.Context, b *backend, records []Record) error) error {
+	if c.writeMode != WriteAll || len(c.backends) < 2 {
+		return nil
+	}
+
+	results := []TargetResult{{URL: origin.url}}
+	failed := false
+	for _, b := range c.backends {
+		if b == origin {
+			continue
+		}
+
+		var records []Record
+		err := c.do(ctx, b, http.MethodGet, "/records", nil, &records, http.StatusOK)
+		c.observe(b, err)
+		if err == nil {
+			err = apply(ctx, b, records)
+		}
+		if err != nil {
+			log.Printf("Failed to apply write to usg-dns-api at %s: %v", b.url, err)
+			failed = true
+		}
+		results = append(results, TargetResult{URL: b.url, Err: err})
+	}
+
+	if !failed {
+		return nil
+	}
+	return &WriteError{Results: results}
+}
+
+// find returns the record named name with the given target, or else the
+// first record named name
+func find(records []Record, name, target string) *Record {
+	var found *Record
+	for i, record := range records {
+		if record.Name != name {
+			continue
+		}
+		if record.Target == target {
+			return &records[i]
+		}
+		if found == nil {
+			found = &records[i]
+		}
+	}
+	return found
+}
+
+// unavailable reports whether err shows that a backend could not be
+// reached or failed, rather than rejected the request
+func unavailable(err error) bool {
+	var transportErr *TransportError
+	var apiErr *APIError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.As(err, &transportErr):
+		return true
+	case errors.As(err, &apiErr):
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
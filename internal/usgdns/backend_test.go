@@ -0,0 +1,171 @@
+package usgdns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGateway is an in-memory usg-dns-api which can be made to fail
+type fakeGateway struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	records []Record
+	nextID  int
+	failing bool
+}
+
+func newFakeGateway(t *testing.T, records ...Record) *fakeGateway {
+	g := &fakeGateway{records: records, nextID: 100}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serveHTTP))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *fakeGateway) setFailing(failing bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.failing = failing
+}
+
+func (g *fakeGateway) names() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var names []string
+	for _, record := range g.records {
+		names = append(names, record.Name+"="+record.Target)
+	}
+	return names
+}
+
+func (g *fakeGateway) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.failing {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/records/")
+	var payload Record
+	json.NewDecoder(r.Body).Decode(&payload)
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(g.records)
+	case http.MethodPost:
+		g.nextID++
+		payload.ID = strconv.Itoa(g.nextID)
+		g.records = append(g.records, payload)
+		json.NewEncoder(w).Encode(payload)
+	case http.MethodPut, http.MethodDelete:
+		for i, record := range g.records {
+			if record.ID != id {
+				continue
+			}
+			if r.Method == http.MethodDelete {
+				g.records = append(g.records[:i], g.records[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			payload.ID = id
+			g.records[i] = payload
+			json.NewEncoder(w).Encode(payload)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestClientFailover(t *testing.T) {
+	primary := newFakeGateway(t, Record{ID: "1", Name: "a.lan", Target: "10.0.0.1"})
+	secondary := newFakeGateway(t, Record{ID: "7", Name: "a.lan", Target: "10.0.0.1"})
+	client := NewClient(primary.URL, "token", WithFailover(secondary.URL))
+	ctx := context.Background()
+
+	primary.setFailing(true)
+	records, err := client.GetRecords(ctx)
+	if err != nil {
+		t.Fatalf("GetRecords failed: %v", err)
+	}
+	if len(records) != 1 || records[0].ID != "7" || records[0].Backend != secondary.URL {
+		t.Errorf("Expected the records of the secondary, got %+v", records)
+	}
+
+	stats := client.Stats()
+	if stats.Switches != 1 || stats.Backends[0].Healthy || !stats.Backends[1].Active {
+		t.Errorf("Expected reads to have switched to the secondary, got %+v", stats)
+	}
+
+	// Writes go to the backend the record was read from
+	if _, err := client.UpdateRecord(ctx, records[0], "a.lan", "10.0.0.2"); err != nil {
+		t.Fatalf("UpdateRecord failed: %v", err)
+	}
+	if got := secondary.names(); len(got) != 1 || got[0] != "a.lan=10.0.0.2" {
+		t.Errorf("Expected the secondary to be updated, got %v", got)
+	}
+
+	// Reads move back once the primary answers again
+	primary.setFailing(false)
+	records, err = client.GetRecords(ctx)
+	if err != nil {
+		t.Fatalf("GetRecords failed: %v", err)
+	}
+	if records[0].Backend != secondary.URL {
+		t.Errorf("Expected reads to stay on the secondary until the primary is checked, got %s", records[0].Backend)
+	}
+	client.observe(client.backends[0], nil)
+	records, _ = client.GetRecords(ctx)
+	if records[0].Backend != primary.URL || client.Stats().Switches != 2 {
+		t.Errorf("Expected reads to move back to the primary, got %s and %+v", records[0].Backend, client.Stats())
+	}
+}
+
+func TestClientWriteAll(t *testing.T) {
+	primary := newFakeGateway(t)
+	secondary := newFakeGateway(t, Record{ID: "7", Name: "b.lan", Target: "10.0.0.9"})
+	client := NewClient(primary.URL, "token", WithFailover(secondary.URL), WithWriteMode(WriteAll))
+	ctx := context.Background()
+
+	record, err := client.CreateRecord(ctx, "a.lan", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("CreateRecord failed: %v", err)
+	}
+	if _, err := client.UpdateRecord(ctx, *record, "a.lan", "10.0.0.2"); err != nil {
+		t.Fatalf("UpdateRecord failed: %v", err)
+	}
+	if got := secondary.names(); len(got) != 2 || got[1] != "a.lan=10.0.0.2" {
+		t.Errorf("Expected the write to reach the secondary, got %v", got)
+	}
+
+	// A missing record is created on the secondary by an update
+	if _, err := client.UpdateRecord(ctx, Record{ID: "7", Name: "b.lan", Target: "10.0.0.9", Backend: secondary.URL}, "b.lan", "10.0.0.8"); err != nil {
+		t.Fatalf("UpdateRecord failed: %v", err)
+	}
+	if got := primary.names(); len(got) != 2 || got[1] != "b.lan=10.0.0.8" {
+		t.Errorf("Expected the record to be created on the primary, got %v", got)
+	}
+
+	// A secondary failing does not undo the write on the primary
+	secondary.setFailing(true)
+	err = client.DeleteRecord(ctx, *record)
+	var writeErr *WriteError
+	if !errors.As(err, &writeErr) {
+		t.Fatalf("Expected a WriteError, got %v", err)
+	}
+	if len(writeErr.Results) != 2 || writeErr.Results[0].Err != nil || writeErr.Results[1].URL != secondary.URL || writeErr.Results[1].Err == nil {
+		t.Errorf("Unexpected results: %+v", writeErr.Results)
+	}
+	if got := primary.names(); len(got) != 1 {
+		t.Errorf("Expected the record to be deleted on the primary, got %v", got)
+	}
+}
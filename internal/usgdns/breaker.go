@@ -0,0 +1,137 @@
+package usgdns
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, wrapped in a TransportError, when requests
+// are not sent because usg-dns-api failed too many times in a row
+var ErrCircuitOpen = errors.New("circuit breaker is open after consecutive failures of usg-dns-api")
+
+// BreakerState is the state of the circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed BreakerState = iota
+	// BreakerHalfOpen lets a single probe request through after the
+	// cooldown, which closes the breaker if it succeeds
+	BreakerHalfOpen
+	// BreakerOpen fails requests without sending them
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker opens after threshold consecutive failures, and lets a probe
+// request through once cooldown has elapsed
+type breaker struct {
+	// url is the backend the breaker protects, for the logs
+	url       string
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// opened and rejected count the times the breaker opened and the
+	// requests it failed
+	opened   uint64
+	rejected uint64
+}
+
+func newBreaker(url string, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{url: url, threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a request can be sent. A request allowed while
+// half-open is the probe, and must be followed by record or release.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			b.rejected++
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			b.rejected++
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of a request
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		if b.state != BreakerClosed {
+			log.Printf("usg-dns-api at %s answered again, closing the circuit breaker", b.url)
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			b.opened++
+			log.Printf("usg-dns-api at %s failed %d times in a row, opening the circuit breaker for %s", b.url, b.failures, b.cooldown)
+		}
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		b.probing = false
+	}
+}
+
+// release gives back the probe of a request which ended without telling
+// whether usg-dns-api works, such as one canceled by the caller
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// snapshot returns the state and counters of the breaker
+func (b *breaker) snapshot() (BreakerState, uint64, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	// An open breaker past its cooldown lets the next request through
+	if state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		state = BreakerHalfOpen
+	}
+	return state, b.opened, b.rejected
+}
@@ -0,0 +1,97 @@
+package usgdns
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	now := time.Now()
+	b := newBreaker("http://gateway", 2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.record(true)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected the breaker to stay closed below the threshold, got %v", err)
+	}
+	b.record(true)
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected the breaker to open at the threshold, got %v", err)
+	}
+
+	// After the cooldown, a single probe goes through
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected a probe after the cooldown, got %v", err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected a single probe while half-open, got %v", err)
+	}
+
+	// A failed probe opens the breaker again
+	b.record(true)
+	if state, opened, _ := b.snapshot(); state != BreakerOpen || opened != 2 {
+		t.Fatalf("Expected the breaker to open again, got %s after %d openings", state, opened)
+	}
+
+	now = now.Add(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatalf("Expected a probe after the cooldown, got %v", err)
+	}
+	b.record(false)
+	if state, _, rejected := b.snapshot(); state != BreakerClosed || rejected != 2 {
+		t.Errorf("Expected a successful probe to close the breaker, got %s with %d rejected", state, rejected)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	requests := 0
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer gateway.Close()
+
+	client := NewClient(gateway.URL, "token", WithCircuitBreaker(2, time.Hour))
+	for range 3 {
+		client.GetRecords(context.Background())
+	}
+
+	_, err := client.GetRecords(context.Background())
+	var transportErr *TransportError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &transportErr) {
+		t.Errorf("Expected ErrCircuitOpen in a TransportError, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected requests to stop reaching the gateway once open, got %d", requests)
+	}
+	if client.Ready() == nil || client.Stats().Backends[0].BreakerState != BreakerOpen {
+		t.Errorf("Expected the client not to be ready, got %+v", client.Stats())
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer gateway.Close()
+
+	client := NewClient(gateway.URL, "token", WithRateLimit(20, 1))
+	start := time.Now()
+	for range 3 {
+		if _, err := client.GetRecords(context.Background()); err != nil {
+			t.Fatalf("GetRecords failed: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected requests beyond the burst to wait, took %s", elapsed)
+	}
+	if limited := client.Stats().Backends[0].RateLimited; limited != 2 {
+		t.Errorf("Expected 2 rate limited requests, got %d", limited)
+	}
+}
@@ -2,19 +2,94 @@ package usgdns
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/filewatch"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/tlsutil"
+	"github.com/rclsilver-org/external-dns-usg-dns-api/internal/tracing"
 )
 
-// Client represents a client for the USG DNS API
+// Client represents a client for the USG DNS API. It can send requests to
+// several instances of usg-dns-api, its backends, reading from the first
+// available one and writing as set by WithWriteMode.
 type Client struct {
-	baseURL    string
-	token      string
-	httpClient *http.Client
+	urls      []string
+	backends  []*backend
+	writeMode string
+	token     atomic.Pointer[string]
+	tokenFile string
+	tls       *tlsutil.ClientConfig
+	auth      Authenticator
+	timeout   time.Duration
+
+	rateLimit        float64
+	rateBurst        int
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	// mu guards active, the index of the backend serving reads
+	mu       sync.Mutex
+	active   int
+	switches atomic.Uint64
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTokenFile makes the client read its token again from path when the
+// file changes and when usg-dns-api rejects the current one. An empty path
+// disables it.
+func WithTokenFile(path string) Option {
+	return func(c *Client) {
+		c.tokenFile = path
+	}
+}
+
+// WithTLS makes the client connect with the TLS configuration of config,
+// whose files are reloaded by WatchTLS. A nil config keeps the defaults.
+func WithTLS(config *tlsutil.ClientConfig) Option {
+	return func(c *Client) {
+		if config == nil {
+			return
+		}
+		c.tls = config
+	}
+}
+
+// WithRateLimit limits the requests sent to each backend to perSecond,
+// allowing bursts of burst requests. Requests wait for their turn. A
+// perSecond of zero disables the limit.
+func WithRateLimit(perSecond float64, burst int) Option {
+	return func(c *Client) {
+		c.rateLimit, c.rateBurst = perSecond, burst
+	}
+}
+
+// WithCircuitBreaker fails requests without sending them for cooldown
+// after threshold consecutive transport errors or 5xx responses, then lets
+// a single probe through to find out whether usg-dns-api is back. Each
+// backend has its own breaker. A threshold of zero disables them.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.breakerThreshold, c.breakerCooldown = threshold, cooldown
+	}
 }
 
 // Record represents a DNS record in usg-dns-api
@@ -22,144 +97,329 @@ type Record struct {
 	ID     string `json:"id"`
 	Name   string `json:"name"`
 	Target string `json:"target"`
+	// Backend is the URL of the usg-dns-api instance the record was read
+	// from, its ID being only meaningful there
+	Backend string `json:"-" yaml:"-"`
 }
 
 // NewClient creates a new USG DNS API client
-func NewClient(baseURL, token string) *Client {
-	return &Client{
-		baseURL: strings.TrimSuffix(baseURL, "/"),
-		token:   token,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+func NewClient(baseURL, token string, opts ...Option) *Client {
+	c := &Client{
+		urls:      []string{baseURL},
+		writeMode: WritePrimary,
+		auth:      RawToken(),
+		timeout:   30 * time.Second,
 	}
-}
+	c.token.Store(&token)
 
-// GetRecords retrieves all DNS records
-func (c *Client) GetRecords() ([]Record, error) {
-	req, err := http.NewRequest("GET", c.baseURL+"/records", nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+	for _, opt := range opts {
+		opt(c)
 	}
 
-	req.Header.Set("Authorization", c.token)
+	for _, u := range c.urls {
+		c.backends = append(c.backends, c.newBackend(u))
+	}
+
+	return c
+}
 
-	resp, err := c.httpClient.Do(req)
+// SetToken replaces the token sent with the following requests
+func (c *Client) SetToken(token string) {
+	c.token.Store(&token)
+}
+
+// ReloadToken reads the token file again and reports whether the token
+// changed. An empty file is an error, so that a Secret being rewritten
+// never leaves the client without a token.
+func (c *Client) ReloadToken() (bool, error) {
+	if c.tokenFile == "" {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(c.tokenFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return false, fmt.Errorf("failed to read token file: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return false, fmt.Errorf("token file %s is empty", c.tokenFile)
+	}
+	if token == *c.token.Load() {
+		return false, nil
 	}
 
-	var records []Record
-	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	c.SetToken(token)
+	return true, nil
+}
+
+// WatchToken reloads the token whenever the token file changes. It blocks
+// until ctx is done, and returns immediately without a token file.
+func (c *Client) WatchToken(ctx context.Context, interval time.Duration) {
+	if c.tokenFile == "" {
+		return
 	}
 
-	return records, nil
+	filewatch.Watch(ctx, interval, func() {
+		changed, err := c.ReloadToken()
+		if err != nil {
+			log.Printf("Failed to reload usg-dns-api token, keeping the previous one: %v", err)
+			return
+		}
+		if changed {
+			log.Printf("Reloaded usg-dns-api token")
+		}
+	}, c.tokenFile)
 }
 
-// CreateRecord creates a new DNS record
-func (c *Client) CreateRecord(name, target string) (*Record, error) {
-	payload := map[string]string{
-		"name":   name,
-		"target": target,
+// WatchTLS reloads the CA bundle and client certificate whenever their
+// files change. It blocks until ctx is done, and returns immediately
+// without a TLS configuration.
+func (c *Client) WatchTLS(ctx context.Context, interval time.Duration) {
+	if c.tls == nil {
+		return
 	}
+	c.tls.Watch(ctx, interval)
+}
 
-	body, err := json.Marshal(payload)
+// GetRecords retrieves all DNS records from the first available backend
+func (c *Client) GetRecords(ctx context.Context) ([]Record, error) {
+	var records []Record
+	b, err := c.read(ctx, "/records", &records)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		return nil, err
+	}
+	for i := range records {
+		records[i].Backend = b.url
 	}
+	return records, nil
+}
 
-	req, err := http.NewRequest("POST", c.baseURL+"/records", bytes.NewBuffer(body))
+// CreateRecord creates a new DNS record on the backend serving reads. In
+// write mode all, it is also created on the other backends, a *WriteError
+// being returned with the record when that failed on some of them.
+func (c *Client) CreateRecord(ctx context.Context, name, target string) (*Record, error) {
+	origin := c.primary()
+	record, err := c.create(ctx, origin, name, target)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
 
-	req.Header.Set("Authorization", c.token)
-	req.Header.Set("Content-Type", "application/json")
+	return record, c.mirror(ctx, origin, func(ctx context.Context, b *backend, records []Record) error {
+		if existing := find(records, name, target); existing != nil && existing.Target == target {
+			return nil
+		}
+		_, err := c.create(ctx, b, name, target)
+		return err
+	})
+}
 
-	resp, err := c.httpClient.Do(req)
+// UpdateRecord updates an existing DNS record on the backend it was read
+// from. In write mode all, the record with the same name and target is
+// also updated on the other backends, or created where it is missing.
+func (c *Client) UpdateRecord(ctx context.Context, record Record, name, target string) (*Record, error) {
+	origin := c.backendOf(record)
+	updated, err := c.update(ctx, origin, record.ID, name, target)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
-	}
+	return updated, c.mirror(ctx, origin, func(ctx context.Context, b *backend, records []Record) error {
+		existing := find(records, record.Name, record.Target)
+		switch {
+		case existing == nil:
+			_, err := c.create(ctx, b, name, target)
+			return err
+		case existing.Name == name && existing.Target == target:
+			return nil
+		default:
+			_, err := c.update(ctx, b, existing.ID, name, target)
+			return err
+		}
+	})
+}
 
-	var record Record
-	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+// DeleteRecord deletes a DNS record on the backend it was read from. In
+// write mode all, the record with the same name is also deleted on the
+// other backends where it exists.
+func (c *Client) DeleteRecord(ctx context.Context, record Record) error {
+	origin := c.backendOf(record)
+	if err := c.delete(ctx, origin, record.ID); err != nil {
+		return err
 	}
 
-	return &record, nil
+	return c.mirror(ctx, origin, func(ctx context.Context, b *backend, records []Record) error {
+		existing := find(records, record.Name, record.Target)
+		if existing == nil {
+			return nil
+		}
+		return c.delete(ctx, b, existing.ID)
+	})
 }
 
-// UpdateRecord updates an existing DNS record
-func (c *Client) UpdateRecord(id, name, target string) (*Record, error) {
+func (c *Client) create(ctx context.Context, b *backend, name, target string) (*Record, error) {
 	payload := map[string]string{
 		"name":   name,
 		"target": target,
 	}
 
-	body, err := json.Marshal(payload)
+	var record Record
+	err := c.do(ctx, b, http.MethodPost, "/records", payload, &record, http.StatusOK, http.StatusCreated)
+	c.observe(b, err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+		return nil, err
+	}
+	record.Backend = b.url
+	return &record, nil
+}
+
+func (c *Client) update(ctx context.Context, b *backend, id, name, target string) (*Record, error) {
+	payload := map[string]string{
+		"name":   name,
+		"target": target,
 	}
 
-	req, err := http.NewRequest("PUT", c.baseURL+"/records/"+id, bytes.NewBuffer(body))
+	var record Record
+	err := c.do(ctx, b, http.MethodPut, "/records/"+url.PathEscape(id), payload, &record, http.StatusOK)
+	c.observe(b, err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
+	record.Backend = b.url
+	return &record, nil
+}
 
-	req.Header.Set("Authorization", c.token)
-	req.Header.Set("Content-Type", "application/json")
+func (c *Client) delete(ctx context.Context, b *backend, id string) error {
+	err := c.do(ctx, b, http.MethodDelete, "/records/"+url.PathEscape(id), nil, nil, http.StatusOK, http.StatusNoContent)
+	c.observe(b, err)
+	return err
+}
 
-	resp, err := c.httpClient.Do(req)
+// do sends a request to the API, checks the status code against the
+// expected ones and decodes the response body into out when it is not nil.
+// When the token is rejected and the token file holds a new one, the
+// request is sent again once with it.
+func (c *Client) do(ctx context.Context, b *backend, method, path string, payload, out any, expected ...int) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "usgdns "+method+" "+path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(method),
+			semconv.URLFull(b.url+path),
+		),
+	)
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	var data []byte
+	if payload != nil {
+		data, err = json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+	}
+
+	resp, err := c.send(ctx, b, method, path, data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		changed, reloadErr := c.ReloadToken()
+		if reloadErr != nil {
+			log.Printf("Failed to reload usg-dns-api token after it was rejected: %v", reloadErr)
+		}
+		if changed {
+			log.Printf("usg-dns-api rejected the token, retrying with the new one from %s", c.tokenFile)
+			resp.Body.Close()
+			resp, err = c.send(ctx, b, method, path, data)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	if !statusIn(resp.StatusCode, expected) {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
+		return &APIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
 	}
 
-	var record Record
-	if err := json.NewDecoder(resp.Body).Decode(&record); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if out == nil {
+		return nil
 	}
 
-	return &record, nil
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
 }
 
-// DeleteRecord deletes a DNS record
-func (c *Client) DeleteRecord(id string) error {
-	req, err := http.NewRequest("DELETE", c.baseURL+"/records/"+id, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// send sends a single request authenticated with the current token, once
+// the rate limiter and the circuit breaker of the backend let it through
+func (c *Client) send(ctx context.Context, b *backend, method, path string, data []byte) (*http.Response, error) {
+	if b.limiter != nil {
+		if b.limiter.Tokens() < 1 {
+			b.rateLimited.Add(1)
+		}
+		if err := b.limiter.Wait(ctx); err != nil {
+			return nil, &TransportError{Err: err}
+		}
+	}
+
+	if b.breaker != nil {
+		if err := b.breaker.allow(); err != nil {
+			return nil, &TransportError{Err: err}
+		}
+	}
+
+	resp, err := c.roundTrip(ctx, b, method, path, data)
+
+	if b.breaker != nil {
+		// A request canceled by the caller tells nothing about usg-dns-api
+		if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+			b.breaker.release()
+		} else {
+			b.breaker.record(err != nil || resp.StatusCode >= http.StatusInternalServerError)
+		}
 	}
+	return resp, err
+}
 
-	req.Header.Set("Authorization", c.token)
+// roundTrip builds and sends a request
+func (c *Client) roundTrip(ctx context.Context, b *backend, method, path string, data []byte) (*http.Response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
 
-	resp, err := c.httpClient.Do(req)
+	req, err := http.NewRequestWithContext(ctx, method, b.url+path, body)
 	if err != nil {
-		return fmt.Errorf("failed to execute request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	c.auth.Authenticate(req, data, *c.token.Load())
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
-	return nil
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, &TransportError{Err: err}
+	}
+	return resp, nil
+}
+
+func statusIn(status int, expected []int) bool {
+	for _, s := range expected {
+		if status == s {
+			return true
+		}
+	}
+	return false
 }
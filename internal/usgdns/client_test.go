@@ -0,0 +1,104 @@
+package usgdns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestClientRetriesWithRotatedToken(t *testing.T) {
+	var seen []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")
+		seen = append(seen, token)
+		if token != "new-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"id":"1","name":"a.example.com","target":"1.2.3.4"}]`))
+	}))
+	defer gateway.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("old-token\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	client := NewClient(gateway.URL, "old-token", WithTokenFile(tokenFile))
+
+	// The file still holds the rejected token: no retry
+	if _, err := client.GetRecords(context.Background()); err == nil {
+		t.Fatal("Expected the rejected token to fail")
+	}
+	if len(seen) != 1 {
+		t.Fatalf("Expected a single request with an unchanged token file, got %v", seen)
+	}
+
+	// The Secret was rotated: the request is retried with the new token
+	if err := os.WriteFile(tokenFile, []byte("new-token\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	seen = nil
+	records, err := client.GetRecords(context.Background())
+	if err != nil {
+		t.Fatalf("GetRecords failed: %v", err)
+	}
+	if len(records) != 1 || len(seen) != 2 || seen[1] != "new-token" {
+		t.Errorf("Expected a retry with the new token, got %v and %v", records, seen)
+	}
+}
+
+func TestReloadTokenKeepsTokenOnEmptyFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	client := NewClient("http://test.local", "token", WithTokenFile(tokenFile))
+
+	if _, err := client.ReloadToken(); err == nil {
+		t.Error("Expected an empty token file to be rejected")
+	}
+	if token := *client.token.Load(); token != "token" {
+		t.Errorf("Expected the previous token to be kept, got %q", token)
+	}
+}
+
+func TestClientPropagatesTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tracerProvider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	var traceparent string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`[]`))
+	}))
+	defer gateway.Close()
+
+	if _, err := NewClient(gateway.URL, "token").GetRecords(context.Background()); err != nil {
+		t.Fatalf("GetRecords failed: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].SpanKind() != trace.SpanKindClient {
+		t.Fatalf("Expected a client span, got %v", spans)
+	}
+	// The gateway sees the client span as the parent of its work
+	want := "00-" + spans[0].SpanContext().TraceID().String() + "-" + spans[0].SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("Expected traceparent %q, got %q", want, traceparent)
+	}
+}
@@ -0,0 +1,54 @@
+package usgdns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// APIError is returned when usg-dns-api answers with an unexpected status
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.Body)
+}
+
+// TransportError is returned when usg-dns-api could not be reached or did
+// not answer in time
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("failed to execute request: %v", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// TargetResult is the outcome of a write on one backend, Err being nil
+// when it was applied
+type TargetResult struct {
+	URL string
+	Err error
+}
+
+// WriteError is returned in write mode all when a write applied on the
+// backend of the record could not be applied on some of the others.
+// Results lists the outcome on every backend, that of the record first.
+type WriteError struct {
+	Results []TargetResult
+}
+
+func (e *WriteError) Error() string {
+	var failures []string
+	for _, result := range e.Results {
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", result.URL, result.Err))
+		}
+	}
+	return "not applied on " + strings.Join(failures, ", ")
+}
@@ -7,19 +7,19 @@ type DomainFilter struct {
 
 // Endpoint represents a DNS record
 type Endpoint struct {
-	DNSName          string                     `json:"dnsName,omitempty"`
-	Targets          []string                   `json:"targets,omitempty"`
-	RecordType       string                     `json:"recordType,omitempty"`
-	SetIdentifier    string                     `json:"setIdentifier,omitempty"`
-	RecordTTL        int64                      `json:"recordTTL,omitempty"`
-	Labels           map[string]string          `json:"labels,omitempty"`
-	ProviderSpecific []ProviderSpecificProperty `json:"providerSpecific,omitempty"`
+	DNSName          string                     `json:"dnsName,omitempty" yaml:"dnsName,omitempty"`
+	Targets          []string                   `json:"targets,omitempty" yaml:"targets,omitempty"`
+	RecordType       string                     `json:"recordType,omitempty" yaml:"recordType,omitempty"`
+	SetIdentifier    string                     `json:"setIdentifier,omitempty" yaml:"setIdentifier,omitempty"`
+	RecordTTL        int64                      `json:"recordTTL,omitempty" yaml:"recordTTL,omitempty"`
+	Labels           map[string]string          `json:"labels,omitempty" yaml:"labels,omitempty"`
+	ProviderSpecific []ProviderSpecificProperty `json:"providerSpecific,omitempty" yaml:"providerSpecific,omitempty"`
 }
 
 // ProviderSpecificProperty holds provider specific configuration
 type ProviderSpecificProperty struct {
-	Name  string `json:"name,omitempty"`
-	Value string `json:"value,omitempty"`
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
 }
 
 // Changes holds the changes to be applied
@@ -29,3 +29,8 @@ type Changes struct {
 	UpdateNew []*Endpoint `json:"updateNew,omitempty"`
 	Delete    []*Endpoint `json:"delete,omitempty"`
 }
+
+// IsEmpty reports whether there is nothing to change
+func (c *Changes) IsEmpty() bool {
+	return len(c.Create)+len(c.UpdateOld)+len(c.UpdateNew)+len(c.Delete) == 0
+}
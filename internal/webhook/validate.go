@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Validate checks the structure of the changes and returns every problem
+// found, or nil when the changes are valid
+func (c *Changes) Validate() []string {
+	var problems []string
+
+	problems = append(problems, validateEndpoints("create", c.Create, true)...)
+	problems = append(problems, validateEndpoints("updateOld", c.UpdateOld, false)...)
+	problems = append(problems, validateEndpoints("updateNew", c.UpdateNew, true)...)
+	problems = append(problems, validateEndpoints("delete", c.Delete, false)...)
+
+	if len(c.UpdateOld) != len(c.UpdateNew) {
+		problems = append(problems, fmt.Sprintf("updateOld has %d endpoints but updateNew has %d", len(c.UpdateOld), len(c.UpdateNew)))
+	}
+
+	return problems
+}
+
+// ValidateEndpoints checks the structure of a list of endpoints and returns
+// every problem found, or nil when they are valid
+func ValidateEndpoints(endpoints []*Endpoint) []string {
+	return validateEndpoints("endpoints", endpoints, false)
+}
+
+func validateEndpoints(field string, endpoints []*Endpoint, requireTargets bool) []string {
+	var problems []string
+
+	for i, endpoint := range endpoints {
+		prefix := fmt.Sprintf("%s[%d]", field, i)
+
+		if endpoint == nil {
+			problems = append(problems, prefix+": endpoint is null")
+			continue
+		}
+
+		if endpoint.DNSName == "" {
+			problems = append(problems, prefix+": dnsName is required")
+		} else if err := validateDNSName(endpoint.DNSName); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid dnsName %q: %v", prefix, endpoint.DNSName, err))
+		}
+
+		if requireTargets && len(endpoint.Targets) == 0 {
+			problems = append(problems, prefix+": at least one target is required")
+		}
+
+		if endpoint.RecordType == "A" || endpoint.RecordType == "" {
+			for j, target := range endpoint.Targets {
+				if addr, err := netip.ParseAddr(target); err != nil || !addr.Is4() {
+					problems = append(problems, fmt.Sprintf("%s.targets[%d]: %q is not an IPv4 address", prefix, j, target))
+				}
+			}
+		}
+
+		if endpoint.RecordTTL < 0 {
+			problems = append(problems, fmt.Sprintf("%s: recordTTL must not be negative", prefix))
+		}
+	}
+
+	return problems
+}
+
+// validateDNSName checks a name against the RFC 1035 length limits. Any
+// character except dots and whitespace is accepted in labels since
+// external-dns also manages names such as wildcards and TXT registry
+// prefixes.
+func validateDNSName(name string) error {
+	name = strings.TrimSuffix(name, ".")
+
+	if len(name) > 253 {
+		return fmt.Errorf("longer than 253 characters")
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return fmt.Errorf("empty label")
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("label %q is longer than 63 characters", label)
+		}
+		if strings.ContainsAny(label, " \t\r\n") {
+			return fmt.Errorf("label %q contains whitespace", label)
+		}
+	}
+
+	return nil
+}